@@ -24,16 +24,10 @@ import (
 // delete segment
 func (sstore *SStore) gcWal() error {
 	walFiles := sstore.files.getWalFiles()
-	segmentFiles := sstore.files.getSegmentFiles()
-	if len(segmentFiles) == 0 {
+	LastEntryID := sstore.lastSegmentEntryID()
+	if LastEntryID == 0 {
 		return nil
 	}
-	last := segmentFiles[len(segmentFiles)-1]
-	segment := sstore.committer.getSegment(last)
-	if segment == nil {
-		return errors.Errorf("no find segment [%s]", last)
-	}
-	LastEntryID := segment.lastEntryID()
 	skip := sstore.wWriter.walFilename()
 	for _, filename := range walFiles {
 		if filename == skip {
@@ -45,6 +39,7 @@ func (sstore *SStore) gcWal() error {
 			continue
 		}
 		if header.Old && header.LastEntryID <= LastEntryID {
+			size := fileSize(walFile)
 			if err := sstore.files.deleteWal(deleteWal{Filename: filename}); err != nil {
 				return err
 			}
@@ -52,6 +47,11 @@ func (sstore *SStore) gcWal() error {
 				return errors.WithStack(err)
 			}
 			_ = sstore.files.delWalHeader(delWalHeader{Filename: filename})
+			sstore.options.eventListener().OnWalDelete(WalDeleteEvent{
+				Filename:    filename,
+				Size:        size,
+				LastEntryID: header.LastEntryID,
+			})
 		}
 	}
 	return nil
@@ -68,6 +68,17 @@ func (sstore *SStore) gcSegment() error {
 		if segment == nil {
 			return errors.Errorf("no find segment[%s]", filename)
 		}
+		streams := segmentStreams(segment)
+		if sstore.consumerUnread(streams) || sstore.frontSegment(segment, streams) == false {
+			//the segment is kept for the data not read,the later ones are
+			//deleted if they hold the first data of all their streams
+			continue
+		}
+		var begins = make(map[int64]int64, len(streams))
+		for _, stream := range streams {
+			begins[stream.StreamID], _ = sstore.Begin(stream.StreamID)
+		}
+		size := fileSize(filepath.Join(sstore.options.SegmentDir, filename))
 		if err := segment.deleteOnClose(true); err != nil {
 			return err
 		}
@@ -77,6 +88,54 @@ func (sstore *SStore) gcSegment() error {
 		if err := sstore.files.deleteSegment(deleteSegment{Filename: filename}); err != nil {
 			return err
 		}
+		listener := sstore.options.eventListener()
+		listener.OnSegmentDelete(SegmentDeleteEvent{
+			Filename: filename,
+			Size:     size,
+			Streams:  streams,
+		})
+		for _, stream := range streams {
+			end, _ := sstore.End(stream.StreamID)
+			begin, ok := sstore.Begin(stream.StreamID)
+			if ok == false {
+				begin = end
+			}
+			if begin > begins[stream.StreamID] {
+				listener.OnBeginMove(BeginMoveEvent{
+					StreamID: stream.StreamID,
+					OldBegin: begins[stream.StreamID],
+					Begin:    begin,
+					End:      end,
+				})
+			}
+		}
 	}
 	return nil
 }
+
+//frontSegment return true if the segment holds the first data of the streams,
+//the segment after the one kept holds the data of stream in the middle of it
+func (sstore *SStore) frontSegment(segment *segment, streams []StreamSpan) bool {
+	for _, stream := range streams {
+		offsetIndex := sstore.indexTable.get(stream.StreamID)
+		if offsetIndex != nil && offsetIndex.front(segment) == false {
+			return false
+		}
+	}
+	return true
+}
+
+//consumerUnread return true if any registered consumer has not read
+//past the data of the streams,or any queue has not checkpointed past it
+func (sstore *SStore) consumerUnread(streams []StreamSpan) bool {
+	consumers := sstore.committer.consumers
+	for _, stream := range streams {
+		if consumers.pinned(stream.StreamID, stream.End) {
+			return true
+		}
+		if sstore.options.ConsumerRetention && consumers.unread(stream.StreamID, stream.End) {
+			return true
+		}
+	}
+	return false
+}
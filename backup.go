@@ -0,0 +1,240 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const backupManifestFilename = "backup.json"
+
+//BackupFile is a file in the backup dir
+type BackupFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+//BackupManifest describe a backup.the base backup has no parent,
+//an incremental backup only contains the files created since its parent
+type BackupManifest struct {
+	ID       string    `json:"id"`
+	Parent   string    `json:"parent"`
+	CreateTS time.Time `json:"create_ts"`
+	//Segments,Journals are the live files of the store at the backup
+	Segments     []string               `json:"segments"`
+	Journals     []string               `json:"journals"`
+	WalHeaderMap map[string]JournalMeta `json:"wal_header_map"`
+	//Catalog are the streams by name,LastStreamID is the last ID allocated
+	Catalog      map[string]StreamMeta `json:"catalog,omitempty"`
+	LastStreamID int64                 `json:"last_stream_id,omitempty"`
+	//Files are the files in the backup dir,relative to the dir
+	Files []BackupFile `json:"files"`
+}
+
+//Backup export the files of store to the dir,
+//since is the manifest of the previous backup,nil means a base backup.
+//an incremental backup only contains the segments and journals created since
+//the previous one,and the journal which was being written at that time
+func (sstore *SStore) Backup(dir string, since *BackupManifest) (*BackupManifest, error) {
+	if sstore.options.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if err := mkdirEmpty(dir); err != nil {
+		return nil, err
+	}
+	var segmentFilter, walFilter func(filename string) bool
+	var backup = &BackupManifest{
+		ID:       strconv.FormatInt(time.Now().UnixNano(), 10),
+		CreateTS: time.Now(),
+	}
+	if since != nil {
+		backup.Parent = since.ID
+		segmentFilter = func(filename string) bool {
+			return containsString(since.Segments, filename) == false
+		}
+		walFilter = func(filename string) bool {
+			if len(since.Journals) > 0 && since.Journals[len(since.Journals)-1] == filename {
+				return true
+			}
+			return containsString(since.Journals, filename) == false
+		}
+	}
+	options := DefaultOptions(dir)
+	files, err := sstore.exportFiles(options, segmentFilter, walFilter)
+	if err != nil {
+		return nil, err
+	}
+	backup.Segments = files.Segments
+	backup.Journals = files.Journals
+	backup.WalHeaderMap = files.WalHeaderMap
+	backup.Catalog = files.Catalog
+	backup.LastStreamID = files.LastStreamID
+	for _, dir := range []string{options.SegmentDir, options.WalDir} {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, info := range infos {
+			backup.Files = append(backup.Files, BackupFile{
+				Name: filepath.Join(filepath.Base(dir), info.Name()),
+				Size: info.Size(),
+			})
+		}
+	}
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, backupManifestFilename), data, 0666); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return backup, nil
+}
+
+//ReadBackupManifest read the manifest of the backup in dir
+func ReadBackupManifest(dir string) (*BackupManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, backupManifestFilename))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var backup BackupManifest
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &backup, nil
+}
+
+//RestoreBackup rebuild a store in dir from the base backup and the
+//incremental backups after it,in the order they were made.
+//the segments are checked against their CRCs
+func RestoreBackup(dir string, backupDirs ...string) error {
+	if len(backupDirs) == 0 {
+		return errors.Errorf("no backup to restore")
+	}
+	var backups []*BackupManifest
+	for index, backupDir := range backupDirs {
+		backup, err := ReadBackupManifest(backupDir)
+		if err != nil {
+			return err
+		}
+		if index == 0 && backup.Parent != "" {
+			return errors.Errorf("backup [%s] is not a base backup", backupDir)
+		}
+		if index > 0 && backup.Parent != backups[index-1].ID {
+			return errors.Errorf("backup [%s] parent [%s] error,expect [%s]",
+				backupDir, backup.Parent, backups[index-1].ID)
+		}
+		backups = append(backups, backup)
+	}
+	//find the file in the newest backup
+	var locate = func(name string) (string, int64, error) {
+		for index := len(backups) - 1; index >= 0; index-- {
+			for _, file := range backups[index].Files {
+				if file.Name == name {
+					return filepath.Join(backupDirs[index], name), file.Size, nil
+				}
+			}
+		}
+		return "", 0, errors.Errorf("no find [%s] in backups", name)
+	}
+
+	if err := mkdirEmpty(dir); err != nil {
+		return err
+	}
+	options := DefaultOptions(dir)
+	for _, dir := range []string{options.WalDir, options.ManifestDir, options.SegmentDir} {
+		if err := mkdir(dir); err != nil {
+			return err
+		}
+	}
+	last := backups[len(backups)-1]
+	for _, filename := range last.Segments {
+		src, size, err := locate(filepath.Join(filepath.Base(options.SegmentDir), filename))
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(options.SegmentDir, filename)
+		if err := copyFile(src, dst, size); err != nil {
+			return err
+		}
+		segment, err := openSegment(dst)
+		if err != nil {
+			return err
+		}
+		err = segment.verify()
+		_ = segment.close()
+		if err != nil {
+			return err
+		}
+	}
+	for _, filename := range last.Journals {
+		src, size, err := locate(filepath.Join(filepath.Base(options.WalDir), filename))
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(options.WalDir, filename)
+		if err := copyJournal(src, dst); err != nil {
+			return err
+		}
+		if info, err := os.Stat(dst); err != nil {
+			return errors.WithStack(err)
+		} else if info.Size() != size {
+			return errors.Errorf("journal [%s] size %d error,expect %d", src, info.Size(), size)
+		}
+	}
+	return writeManifestSnapshot(options.ManifestDir, 1, &manifest{
+		Segments:     copyStrings(last.Segments),
+		Journals:     copyStrings(last.Journals),
+		WalHeaderMap: last.WalHeaderMap,
+		Catalog:      last.Catalog,
+		LastStreamID: last.LastStreamID,
+	})
+}
+
+//copyFile copy the file,it checks the size of file copied
+func copyFile(src string, dst string, size int64) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if err := writeFile(dst, f); err != nil {
+		return err
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if info.Size() != size {
+		return errors.Errorf("file [%s] size %d error,expect %d", src, info.Size(), size)
+	}
+	return nil
+}
+
+func containsString(strings []string, str string) bool {
+	for _, it := range strings {
+		if it == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,273 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+)
+
+//the StreamIDs from math.MinInt64 are reserved for the control entries,
+//reservedStreamIDs is the count of them and the users can't append to them.
+//batchStreamID is the batch entry,the data of it is the entries of batch.
+//producerStreamID is the batch entry of producer,the producer ID and sequence
+//are before the entries of batch.
+//epochStreamID sets the epoch of stream,the data of it is the StreamID and epoch.
+//fencedStreamID is the batch entry carrying the epoch of writer before the entries.
+//consumerStreamID commits the offset of consumer,the data of it is the StreamID,
+//offset and name of consumer,the offset consumerDeleted deletes the consumer.
+//recordStreamID is the batch entry of record appends,the append mode of streams
+//is before the entries of batch.
+//
+//the journal writer marks the entries not to append with the sentinel offsets
+//to keep the ends of streams,the committer rejects them.
+//offsetFailed is the entry failing to append,offsetDuplicate and offsetOutOfSequence
+//are the producer entries whose sequences are retried or skipped,offsetFenced
+//is the entry rejected with ErrFenced,offsetWrongMode is the entry appending to
+//the stream of other mode and rejected with ErrStreamMode
+const (
+	batchStreamID     = math.MinInt64
+	producerStreamID  = math.MinInt64 + 1
+	epochStreamID     = math.MinInt64 + 2
+	fencedStreamID    = math.MinInt64 + 3
+	consumerStreamID  = math.MinInt64 + 4
+	recordStreamID    = math.MinInt64 + 5
+	reservedStreamIDs = 6
+
+	consumerDeleted     = -1
+	offsetFailed        = -2
+	offsetDuplicate     = -3
+	offsetOutOfSequence = -4
+	offsetFenced        = -5
+	offsetWrongMode     = -6
+)
+
+//checkStreamID return ErrInvalidStreamID if the stream is reserved
+func checkStreamID(streamID int64) error {
+	if streamID < math.MinInt64+reservedStreamIDs {
+		return errors.Wrapf(ErrInvalidStreamID, "stream[%d]", streamID)
+	}
+	return nil
+}
+
+//checkOps return ErrInvalidStreamID if any stream of ops can't be appended
+func (sstore *SStore) checkOps(ops []AppendOp) error {
+	for _, op := range ops {
+		if err := sstore.checkAppend(op.StreamID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//AppendOp is an append of AppendBatch
+type AppendOp struct {
+	StreamID int64
+	Data     []byte
+	//Offset is the expected end of stream,-1 means the end
+	Offset int64
+}
+
+//AppendBatch append the data of ops to the streams all-or-nothing,
+//the ops are written in one journal entry,none of them is appended
+//if the offset of any one is wrong
+func (sstore *SStore) AppendBatch(ops []AppendOp) ([]AppendResult, error) {
+	var err error
+	var results []AppendResult
+	sstore.syncCall(func(done func()) {
+		sstore.AsyncAppendBatch(ops, func(r []AppendResult, e error) {
+			results, err = r, e
+			done()
+		})
+	})
+	return results, err
+}
+
+//AsyncAppendBatch async append the data of ops to the streams all-or-nothing,
+//cb is called once with the results of ops
+func (sstore *SStore) AsyncAppendBatch(ops []AppendOp, cb func(results []AppendResult, err error)) {
+	if len(ops) == 0 {
+		cb(nil, nil)
+		return
+	}
+	sstore.asyncAppendBatchEntry(batchStreamID, nil, ops, cb)
+}
+
+//asyncAppendBatchEntry append the ops in one batch entry of the reserved streamID,
+//header sets the fields of entry encoded before the entries of batch
+func (sstore *SStore) asyncAppendBatchEntry(streamID int64, header func(e *entry),
+	ops []AppendOp, cb func(results []AppendResult, err error)) {
+	if err := sstore.checkOps(ops); err != nil {
+		cb(nil, err)
+		return
+	}
+	var batch = make([]*entry, 0, len(ops))
+	for _, op := range ops {
+		batch = append(batch, &entry{
+			StreamID: op.StreamID,
+			Offset:   op.Offset,
+			data:     op.Data,
+		})
+	}
+	var e = &entry{
+		StreamID: streamID,
+		Offset:   -1,
+		batch:    batch,
+	}
+	if header != nil {
+		header(e)
+	}
+	e.encodeBatch()
+	e.cb = batchCallback(batch, cb)
+	sstore.putEntry(e)
+}
+
+//batchCallback return the callback of batch entry,
+//it calls cb with the results of the entries of batch
+func batchCallback(batch []*entry, cb func(results []AppendResult, err error)) func(int64, error) {
+	return func(_ int64, err error) {
+		if err != nil {
+			cb(nil, err)
+			return
+		}
+		var results = make([]AppendResult, 0, len(batch))
+		for _, it := range batch {
+			results = append(results, AppendResult{
+				EntryID: it.ID,
+				Begin:   it.Offset,
+				End:     it.end,
+			})
+		}
+		cb(results, nil)
+	}
+}
+
+//isBatch return true if the data of entry is the entries of batch
+func (e *entry) isBatch() bool {
+	return e.StreamID == batchStreamID ||
+		e.StreamID == producerStreamID ||
+		e.StreamID == fencedStreamID ||
+		e.StreamID == recordStreamID
+}
+
+//encodeBatch encode the entries of batch to the data of entry
+func (e *entry) encodeBatch() {
+	data := encodeBatch(e.batch)
+	if e.StreamID == producerStreamID {
+		var buffer = bytes.NewBuffer(make([]byte, 0, 16+len(data)))
+		_ = binary.Write(buffer, binary.BigEndian, e.producerID)
+		_ = binary.Write(buffer, binary.BigEndian, e.seq)
+		buffer.Write(data)
+		data = buffer.Bytes()
+	} else if e.StreamID == fencedStreamID {
+		var buffer = bytes.NewBuffer(make([]byte, 0, 8+len(data)))
+		_ = binary.Write(buffer, binary.BigEndian, e.epoch)
+		buffer.Write(data)
+		data = buffer.Bytes()
+	} else if e.StreamID == recordStreamID {
+		data = append([]byte{e.mode}, data...)
+	}
+	e.data = data
+}
+
+//batchEntries return the entries of batch entry
+func (e *entry) batchEntries() ([]*entry, error) {
+	if e.batch == nil {
+		data := e.data
+		if e.StreamID == producerStreamID {
+			if len(data) < 16 {
+				return nil, errors.WithStack(io.ErrUnexpectedEOF)
+			}
+			e.producerID = int64(binary.BigEndian.Uint64(data))
+			e.seq = int64(binary.BigEndian.Uint64(data[8:]))
+			data = data[16:]
+		} else if e.StreamID == fencedStreamID {
+			if len(data) < 8 {
+				return nil, errors.WithStack(io.ErrUnexpectedEOF)
+			}
+			e.epoch = int64(binary.BigEndian.Uint64(data))
+			data = data[8:]
+		} else if e.StreamID == recordStreamID {
+			if len(data) < 1 {
+				return nil, errors.WithStack(io.ErrUnexpectedEOF)
+			}
+			e.mode = data[0]
+			data = data[1:]
+		}
+		batch, err := decodeBatch(data)
+		if err != nil {
+			return nil, err
+		}
+		e.batch = batch
+	}
+	for _, it := range e.batch {
+		it.ID = e.ID
+		it.ver = e.ver
+		it.ts = e.ts
+		it.mode = e.mode
+	}
+	return e.batch, nil
+}
+
+//encodeBatch encode the entries of batch as
+//count,then StreamID,Offset,data length,data of each entry
+func encodeBatch(batch []*entry) []byte {
+	var size = 4
+	for _, e := range batch {
+		size += 8 + 8 + 4 + len(e.data)
+	}
+	var buffer = bytes.NewBuffer(make([]byte, 0, size))
+	_ = binary.Write(buffer, binary.BigEndian, uint32(len(batch)))
+	for _, e := range batch {
+		_ = binary.Write(buffer, binary.BigEndian, e.StreamID)
+		_ = binary.Write(buffer, binary.BigEndian, e.Offset)
+		_ = binary.Write(buffer, binary.BigEndian, uint32(len(e.data)))
+		buffer.Write(e.data)
+	}
+	return buffer.Bytes()
+}
+
+func decodeBatch(data []byte) ([]*entry, error) {
+	reader := bytes.NewReader(data)
+	var count uint32
+	if err := binary.Read(reader, binary.BigEndian, &count); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var batch = make([]*entry, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var e = new(entry)
+		var dataLen uint32
+		if err := binary.Read(reader, binary.BigEndian, &e.StreamID); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err := binary.Read(reader, binary.BigEndian, &e.Offset); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err := binary.Read(reader, binary.BigEndian, &dataLen); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if int(dataLen) > reader.Len() {
+			return nil, errors.WithStack(io.ErrUnexpectedEOF)
+		}
+		e.data = make([]byte, dataLen)
+		if _, err := io.ReadFull(reader, e.data); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		batch = append(batch, e)
+	}
+	return batch, nil
+}
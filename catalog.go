@@ -0,0 +1,228 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"sort"
+	"sync/atomic"
+)
+
+//catalogStreamID is the first StreamID allocated by the catalog,the streams
+//from it are appended only after they are allocated by CreateStream,
+//so the raw appends never collide with the streams of catalog
+const catalogStreamID = 1 << 62
+
+//StreamMeta is the name and attributes of stream in the catalog
+type StreamMeta struct {
+	Name     string            `json:"name"`
+	StreamID int64             `json:"stream_id"`
+	Attrs    map[string]string `json:"attrs,omitempty"`
+}
+
+type dropStream struct {
+	Name string `json:"name"`
+}
+
+func (meta StreamMeta) clone() StreamMeta {
+	var attrs map[string]string
+	if meta.Attrs != nil {
+		attrs = make(map[string]string, len(meta.Attrs))
+		for key, value := range meta.Attrs {
+			attrs[key] = value
+		}
+	}
+	meta.Attrs = attrs
+	return meta
+}
+
+func cloneCatalog(catalog map[string]StreamMeta) map[string]StreamMeta {
+	var clone = make(map[string]StreamMeta, len(catalog))
+	for name, meta := range catalog {
+		clone[name] = meta.clone()
+	}
+	return clone
+}
+
+//createStream add the stream to the catalog,the ID of it is allocated
+//after the last ID allocated if it is 0
+func (f *manifest) createStream(meta StreamMeta) (StreamMeta, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	if _, ok := f.Catalog[meta.Name]; ok {
+		return StreamMeta{}, errors.Wrapf(ErrStreamExist, "stream[%s]", meta.Name)
+	}
+	if meta.StreamID == 0 {
+		meta.StreamID = catalogStreamID
+		if f.LastStreamID >= catalogStreamID {
+			meta.StreamID = f.LastStreamID + 1
+		}
+	}
+	if meta.StreamID > f.LastStreamID {
+		atomic.StoreInt64(&f.LastStreamID, meta.StreamID)
+	}
+	meta = meta.clone()
+	if f.Catalog == nil {
+		f.Catalog = make(map[string]StreamMeta)
+	}
+	f.Catalog[meta.Name] = meta
+	if f.inRecovery {
+		return meta, nil
+	}
+	data, _ := json.Marshal(meta)
+	return meta.clone(), f.writeEntry(createStreamType, data)
+}
+
+//setStreamAttrs replace the attributes of stream
+func (f *manifest) setStreamAttrs(meta StreamMeta) error {
+	f.l.Lock()
+	defer f.l.Unlock()
+	old, ok := f.Catalog[meta.Name]
+	if ok == false {
+		return errors.Wrapf(ErrNoFindStream, "stream[%s]", meta.Name)
+	}
+	old.Attrs = meta.clone().Attrs
+	f.Catalog[meta.Name] = old
+	if f.inRecovery {
+		return nil
+	}
+	data, _ := json.Marshal(StreamMeta{Name: meta.Name, Attrs: meta.Attrs})
+	return f.writeEntry(setStreamAttrsType, data)
+}
+
+//dropStream remove the stream from the catalog
+func (f *manifest) dropStream(drop dropStream) error {
+	f.l.Lock()
+	defer f.l.Unlock()
+	if _, ok := f.Catalog[drop.Name]; ok == false {
+		return errors.Wrapf(ErrNoFindStream, "stream[%s]", drop.Name)
+	}
+	delete(f.Catalog, drop.Name)
+	if f.inRecovery {
+		return nil
+	}
+	data, _ := json.Marshal(drop)
+	return f.writeEntry(dropStreamType, data)
+}
+
+func (f *manifest) getStream(name string) (StreamMeta, bool) {
+	f.l.RLock()
+	defer f.l.RUnlock()
+	meta, ok := f.Catalog[name]
+	return meta.clone(), ok
+}
+
+func (f *manifest) getStreamByID(streamID int64) (StreamMeta, bool) {
+	f.l.RLock()
+	defer f.l.RUnlock()
+	for _, meta := range f.Catalog {
+		if meta.StreamID == streamID {
+			return meta.clone(), true
+		}
+	}
+	return StreamMeta{}, false
+}
+
+//getCatalog return the copy of catalog and the last ID allocated
+func (f *manifest) getCatalog() (map[string]StreamMeta, int64) {
+	f.l.RLock()
+	defer f.l.RUnlock()
+	return cloneCatalog(f.Catalog), f.LastStreamID
+}
+
+//setCatalog replace the catalog with the one written by the owner
+func (f *manifest) setCatalog(catalog map[string]StreamMeta, lastStreamID int64) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.Catalog = cloneCatalog(catalog)
+	atomic.StoreInt64(&f.LastStreamID, lastStreamID)
+}
+
+//allocated return true if the stream is not in the range of catalog
+//or it has been allocated by the catalog
+func (f *manifest) allocated(streamID int64) bool {
+	return streamID < catalogStreamID || streamID <= atomic.LoadInt64(&f.LastStreamID)
+}
+
+//CreateStream add the stream of name with the attributes to the catalog and
+//return the ID allocated.the IDs are allocated from catalogStreamID in order,
+//the appends to the IDs from it are rejected with ErrInvalidStreamID before
+//they are allocated,so the streams of catalog never have the data of raw appends.
+//it returns ErrStreamExist if the name is in the catalog
+func (sstore *SStore) CreateStream(name string, attrs map[string]string) (int64, error) {
+	if sstore.options.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	if name == "" {
+		return 0, errors.Errorf("stream name is empty")
+	}
+	meta, err := sstore.files.createStream(StreamMeta{Name: name, Attrs: attrs})
+	if err != nil {
+		return 0, err
+	}
+	return meta.StreamID, nil
+}
+
+//checkAppend return ErrInvalidStreamID if the stream is reserved,
+//or it is in the range of catalog and not allocated yet
+func (sstore *SStore) checkAppend(streamID int64) error {
+	if err := checkStreamID(streamID); err != nil {
+		return err
+	}
+	if sstore.files.allocated(streamID) == false {
+		return errors.Wrapf(ErrInvalidStreamID, "stream[%d] is not allocated by catalog", streamID)
+	}
+	return nil
+}
+
+//LookupStream return the stream of name in the catalog
+func (sstore *SStore) LookupStream(name string) (StreamMeta, bool) {
+	return sstore.files.getStream(name)
+}
+
+//StreamMeta return the stream of ID in the catalog
+func (sstore *SStore) StreamMeta(streamID int64) (StreamMeta, bool) {
+	return sstore.files.getStreamByID(streamID)
+}
+
+//SetStreamAttrs replace the attributes of the stream of name
+func (sstore *SStore) SetStreamAttrs(name string, attrs map[string]string) error {
+	if sstore.options.ReadOnly {
+		return ErrReadOnly
+	}
+	return sstore.files.setStreamAttrs(StreamMeta{Name: name, Attrs: attrs})
+}
+
+//DropStream remove the stream of name from the catalog,
+//the data of the stream is not deleted and the ID is never allocated again
+func (sstore *SStore) DropStream(name string) error {
+	if sstore.options.ReadOnly {
+		return ErrReadOnly
+	}
+	return sstore.files.dropStream(dropStream{Name: name})
+}
+
+//Catalog return the streams in the catalog by name
+func (sstore *SStore) Catalog() []StreamMeta {
+	catalog, _ := sstore.files.getCatalog()
+	var streams = make([]StreamMeta, 0, len(catalog))
+	for _, meta := range catalog {
+		streams = append(streams, meta)
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].Name < streams[j].Name
+	})
+	return streams
+}
@@ -36,6 +36,10 @@ func (worker *cbWorker) start() {
 				if e == nil {
 					panic(e)
 				}
+				//the committer has run it
+				if e.ID == barrierSignal {
+					continue
+				}
 				if e.cb == nil {
 					panic(fmt.Sprintf("entry.ID %d entry.streamID%d", e.ID, e.StreamID))
 				}
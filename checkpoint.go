@@ -0,0 +1,234 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//Checkpoint create a consistent point-in-time copy of the store in the dir,
+//the segments are hard linked,the journals are copied to the last entry
+//written,Open(DefaultOptions(dir)) opens the copy.
+func (sstore *SStore) Checkpoint(dir string) error {
+	if sstore.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := mkdirEmpty(dir); err != nil {
+		return err
+	}
+	checkpoint, err := sstore.exportFiles(DefaultOptions(dir), nil, nil)
+	if err != nil {
+		return err
+	}
+	return writeManifestSnapshot(DefaultOptions(dir).ManifestDir, 1, checkpoint)
+}
+
+//exportFiles copy the live journals and link the live segments to the dirs
+//of options,the filters choose the files to export,nil means all.
+//it returns the manifest of all the live files
+func (sstore *SStore) exportFiles(options Options,
+	segmentFilter func(filename string) bool,
+	walFilter func(filename string) bool) (*manifest, error) {
+	//GC must not delete the segments and journals being copied
+	sstore.gcLocker.Lock()
+	defer sstore.gcLocker.Unlock()
+
+	for _, dir := range []string{options.WalDir, options.ManifestDir, options.SegmentDir} {
+		if err := mkdir(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	//pin the segments until they are linked
+	var segments []*segment
+	defer func() {
+		for _, segment := range segments {
+			segment.refDec()
+		}
+	}()
+	var lastEntryID int64
+	segmentFiles := sstore.files.getSegmentFiles()
+	for _, filename := range segmentFiles {
+		segment := sstore.committer.getSegment(filename)
+		if segment == nil || segment.refInc() < 0 {
+			return nil, errors.Errorf("no find segment [%s]", filename)
+		}
+		segments = append(segments, segment)
+		//the compacted segment has the LastEntryID of an older one
+		if segment.lastEntryID() > lastEntryID {
+			lastEntryID = segment.lastEntryID()
+		}
+	}
+
+	var files = &manifest{
+		Segments:     copyStrings(segmentFiles),
+		Journals:     make([]string, 0, 4),
+		WalHeaderMap: make(map[string]JournalMeta),
+	}
+	files.Catalog, files.LastStreamID = sstore.files.getCatalog()
+	for _, filename := range sstore.files.getWalFiles() {
+		header, err := sstore.files.getWalHeader(filename)
+		if err == nil {
+			if header.Old && header.LastEntryID <= lastEntryID {
+				continue
+			}
+			files.WalHeaderMap[filename] = header
+		}
+		files.Journals = append(files.Journals, filename)
+		if walFilter != nil && walFilter(filename) == false {
+			continue
+		}
+		if err := copyJournal(filepath.Join(sstore.options.WalDir, filename),
+			filepath.Join(options.WalDir, filename)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, segment := range segments {
+		filename := filepath.Base(segment.filename)
+		if segmentFilter != nil && segmentFilter(filename) == false {
+			continue
+		}
+		if err := linkFile(segment.filename, filepath.Join(options.SegmentDir, filename)); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+//mkdirEmpty create the dir,it returns error when the dir is not empty
+func mkdirEmpty(dir string) error {
+	if err := mkdir(dir); err != nil {
+		return err
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if names, err := f.Readdirnames(1); err != io.EOF {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.Errorf("dir [%s] is not empty,find [%s]", dir, names[0])
+	}
+	return nil
+}
+
+//copyJournal copy the complete entries of the journal,
+//the writer may be appending to the journal
+func copyJournal(src string, dst string) error {
+	journal, err := openJournalReadOnly(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = journal.Close()
+	}()
+	size, err := journal.ReadFrom(0, func(e *entry) error {
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := journal.f.Seek(0, io.SeekStart); err != nil {
+		return errors.WithStack(err)
+	}
+	return writeFile(dst, io.LimitReader(journal.f, size))
+}
+
+//linkFile hard link the file,it copies the file when link failed,
+//eg: dst is on other device
+func linkFile(src string, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return writeFile(dst, f)
+}
+
+func writeFile(filename string, reader io.Reader) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := io.Copy(f, reader); err != nil {
+		_ = f.Close()
+		return errors.WithStack(err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return errors.WithStack(err)
+	}
+	if err := f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+//writeManifestSnapshot write the manifest as the manifest journal filesIndex
+//of the dir,it is renamed from a tmp file,so it is complete or absent
+func writeManifestSnapshot(manifestDir string, filesIndex int64, snapshot *manifest) error {
+	snapshot.EntryID = 1
+	if snapshot.WalHeaderMap == nil {
+		snapshot.WalHeaderMap = make(map[string]JournalMeta)
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	tmpJournal := filepath.Join(manifestDir, strconv.FormatInt(filesIndex, 10)+manifestJournalExtTmp)
+	journal, err := openJournal(tmpJournal)
+	if err != nil {
+		return err
+	}
+	if err := journal.Write(&entry{
+		ID:       snapshot.EntryID,
+		StreamID: manifestSnapshotType,
+		data:     data,
+	}); err != nil {
+		_ = journal.Close()
+		return err
+	}
+	if err := journal.Flush(); err != nil {
+		_ = journal.Close()
+		return err
+	}
+	if err := journal.Sync(); err != nil {
+		_ = journal.Close()
+		return err
+	}
+	if err := journal.Close(); err != nil {
+		return err
+	}
+	filename := strings.ReplaceAll(tmpJournal, manifestJournalExtTmp, manifestJournalExt)
+	if err := os.Rename(tmpJournal, filename); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//sstore-backup make base and incremental backups of a store and restore them
+//
+//	sstore-backup backup -dir STORE -out BACKUP [-since PREVIOUS_BACKUP]
+//	sstore-backup restore -out STORE BASE_BACKUP [INCREMENTAL_BACKUP...]
+//
+//backup opens the store,so the store must not be opened by other process,
+//a running process makes backups with SStore.Backup
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/akzj/sstore"
+	"os"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "backup":
+		err = backup(os.Args[2:])
+	case "restore":
+		err = restore(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sstore-backup: %+v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage:\n"+
+		"  sstore-backup backup -dir STORE -out BACKUP [-since PREVIOUS_BACKUP]\n"+
+		"  sstore-backup restore -out STORE BASE_BACKUP [INCREMENTAL_BACKUP...]\n")
+	os.Exit(2)
+}
+
+func backup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	dir := flags.String("dir", "", "directory of the store")
+	out := flags.String("out", "", "directory of the backup,it must be empty")
+	since := flags.String("since", "", "previous backup,empty means a base backup")
+	_ = flags.Parse(args)
+	if *dir == "" || *out == "" {
+		usage()
+	}
+	var previous *sstore.BackupManifest
+	if *since != "" {
+		var err error
+		if previous, err = sstore.ReadBackupManifest(*since); err != nil {
+			return err
+		}
+	}
+	store, err := sstore.Open(sstore.DefaultOptions(*dir))
+	if err != nil {
+		return err
+	}
+	backup, err := store.Backup(*out, previous)
+	if err != nil {
+		_ = store.Close()
+		return err
+	}
+	if err := store.Close(); err != nil {
+		return err
+	}
+	var size int64
+	for _, file := range backup.Files {
+		size += file.Size
+	}
+	fmt.Printf("backup %s parent %s files %d bytes %d\n",
+		backup.ID, backup.Parent, len(backup.Files), size)
+	return nil
+}
+
+func restore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	out := flags.String("out", "", "directory of the store to rebuild,it must be empty")
+	_ = flags.Parse(args)
+	if *out == "" || flags.NArg() == 0 {
+		usage()
+	}
+	if err := sstore.RestoreBackup(*out, flags.Args()...); err != nil {
+		return err
+	}
+	fmt.Printf("restored %s from %d backups\n", *out, flags.NArg())
+	return nil
+}
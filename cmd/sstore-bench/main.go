@@ -0,0 +1,468 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//sstore-bench run append workloads against a store and report
+//throughput,ack latency,flush and GC stalls and write amplification
+//
+//	sstore-bench -dir X -streams 100 -size 128 -concurrency 8 -duration 30s
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/akzj/sstore"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type config struct {
+	dir         string
+	streams     int
+	size        int
+	concurrency int
+	inflight    int
+	async       bool
+	readers     int
+	duration    time.Duration
+	count       int64
+	gcInterval  time.Duration
+	stall       time.Duration
+	keep        bool
+	options     sstore.Options
+}
+
+//stats is the result of the workers
+type stats struct {
+	l         sync.Mutex
+	latencies []time.Duration
+	errors    int64
+	readBytes int64
+
+	//lastAck is the unix nano of the last ack,it is used to find stalls
+	lastAck     int64
+	stalls      []time.Duration
+	gcDurations []time.Duration
+
+	//lastEntryID is the ID of the last entry acked,the flush backlog
+	//is the count of entries acked after the entries flushed
+	lastEntryID    int64
+	flushDurations []time.Duration
+	flushBacklogs  []int64
+	//flushedBytes,walBytes are the bytes of segments flushed and journals rotated
+	flushedBytes int64
+	walBytes     int64
+	rotatedWals  map[string]bool
+}
+
+func (s *stats) ack(result sstore.AppendResult, latency time.Duration, err error) {
+	atomic.StoreInt64(&s.lastAck, time.Now().UnixNano())
+	s.l.Lock()
+	if err != nil {
+		s.errors++
+	} else if result.EntryID > s.lastEntryID {
+		s.lastEntryID = result.EntryID
+	}
+	s.latencies = append(s.latencies, latency)
+	s.l.Unlock()
+}
+
+//listener record the flushes and journal rotations of the store
+type listener struct {
+	sstore.BaseEventListener
+	s *stats
+}
+
+func (l *listener) OnFlush(event sstore.FlushEvent) {
+	l.s.l.Lock()
+	defer l.s.l.Unlock()
+	l.s.flushDurations = append(l.s.flushDurations, event.Duration)
+	var backlog int64
+	if l.s.lastEntryID > event.LastEntryID {
+		backlog = l.s.lastEntryID - event.LastEntryID
+	}
+	l.s.flushBacklogs = append(l.s.flushBacklogs, backlog)
+	l.s.flushedBytes += event.Size
+}
+
+func (l *listener) OnWalRotate(event sstore.WalRotateEvent) {
+	l.s.l.Lock()
+	defer l.s.l.Unlock()
+	l.s.walBytes += event.Size
+	l.s.rotatedWals[event.Filename] = true
+}
+
+//cleanup remove the temporary directory,fatal calls it before exit
+var cleanup = func() {}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.dir, "dir", "", "directory of the store,a temporary directory when empty")
+	flag.IntVar(&cfg.streams, "streams", 100, "number of streams")
+	flag.IntVar(&cfg.size, "size", 128, "payload size of an append")
+	flag.IntVar(&cfg.concurrency, "concurrency", 8, "number of append workers")
+	flag.IntVar(&cfg.inflight, "inflight", 64, "max unacked appends of an async worker")
+	flag.BoolVar(&cfg.async, "async", false, "use AsyncAppend instead of Append")
+	flag.IntVar(&cfg.readers, "readers", 0, "number of readers following the streams while writing")
+	flag.DurationVar(&cfg.duration, "duration", 10*time.Second, "how long to run the workload")
+	flag.Int64Var(&cfg.count, "count", 0, "number of appends to run,it overrides -duration when > 0")
+	flag.DurationVar(&cfg.gcInterval, "gc", time.Second, "interval to call GC,0 means never")
+	flag.DurationVar(&cfg.stall, "stall", 10*time.Millisecond, "min time without ack to count as a stall")
+	flag.BoolVar(&cfg.keep, "keep", false, "keep the temporary directory")
+
+	maxTableSize := flag.Int64("max-table-size", 0, "Options.MaxMStreamTableSize")
+	maxImmutable := flag.Int("max-immutable-tables", 0, "Options.MaxImmutableMStreamTableCount")
+	blockSize := flag.Int("block-size", 0, "Options.BlockSize")
+	entryQueueCap := flag.Int("entry-queue-cap", 0, "Options.EntryQueueCap")
+	maxWalSize := flag.Int64("max-wal-size", 0, "Options.MaxWalSize")
+	maxSegmentCount := flag.Int("max-segment-count", 0, "Options.MaxSegmentCount")
+	flag.Parse()
+
+	if cfg.dir == "" {
+		dir, err := ioutil.TempDir("", "sstore-bench")
+		if err != nil {
+			fatal(err)
+		}
+		cfg.dir = dir
+		if cfg.keep == false {
+			cleanup = func() {
+				_ = os.RemoveAll(dir)
+			}
+			defer cleanup()
+		}
+	}
+	cfg.options = sstore.DefaultOptions(cfg.dir)
+	if *maxTableSize > 0 {
+		cfg.options = cfg.options.WithMaxMStreamTableSize(*maxTableSize)
+	}
+	if *maxImmutable > 0 {
+		cfg.options = cfg.options.WithMaxImmutableMStreamTableCount(*maxImmutable)
+	}
+	if *blockSize > 0 {
+		cfg.options = cfg.options.WithBlockSize(*blockSize)
+	}
+	if *entryQueueCap > 0 {
+		cfg.options = cfg.options.WithEntryQueueCap(*entryQueueCap)
+	}
+	if *maxWalSize > 0 {
+		cfg.options = cfg.options.WithMaxWalSize(*maxWalSize)
+	}
+	if *maxSegmentCount > 0 {
+		cfg.options = cfg.options.WithMaxSegmentCount(*maxSegmentCount)
+	}
+	if err := run(cfg); err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "sstore-bench: %+v\n", err)
+	//os.Exit skips the deferred calls
+	cleanup()
+	os.Exit(1)
+}
+
+func run(cfg config) error {
+	var s = &stats{
+		lastAck:     time.Now().UnixNano(),
+		rotatedWals: make(map[string]bool),
+	}
+	store, err := sstore.Open(cfg.options.WithEventListener(&listener{s: s}))
+	if err != nil {
+		return err
+	}
+	var payload = []byte(strings.Repeat("x", cfg.size))
+	var appended int64
+	var stop int32
+	var done = make(chan interface{})
+
+	next := func() (int64, bool) {
+		if atomic.LoadInt32(&stop) == 1 {
+			return 0, false
+		}
+		i := atomic.AddInt64(&appended, 1)
+		if cfg.count > 0 && i > cfg.count {
+			return 0, false
+		}
+		return i, true
+	}
+
+	begin := time.Now()
+
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if cfg.async {
+				asyncWorker(store, s, payload, cfg, next)
+			} else {
+				syncWorker(store, s, payload, cfg, next)
+			}
+		}()
+	}
+
+	var background sync.WaitGroup
+	for r := 0; r < cfg.readers; r++ {
+		background.Add(1)
+		go func(r int) {
+			defer background.Done()
+			follow(store, s, cfg, r, done)
+		}(r)
+	}
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		watchStalls(s, cfg, done)
+	}()
+	if cfg.gcInterval > 0 {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			runGC(store, s, cfg, done)
+		}()
+	}
+	if cfg.count == 0 {
+		time.AfterFunc(cfg.duration, func() {
+			atomic.StoreInt32(&stop, 1)
+		})
+	}
+	wg.Wait()
+	elapsed := time.Since(begin)
+	close(done)
+	background.Wait()
+
+	if err := store.Close(); err != nil {
+		return err
+	}
+	written := writtenBytes(cfg, s)
+	report(cfg, s, elapsed, written)
+	return nil
+}
+
+func syncWorker(store *sstore.SStore, s *stats, payload []byte, cfg config, next func() (int64, bool)) {
+	for {
+		i, ok := next()
+		if ok == false {
+			return
+		}
+		begin := time.Now()
+		result, err := store.AppendWithResult(i%int64(cfg.streams), payload, -1)
+		s.ack(result, time.Since(begin), err)
+	}
+}
+
+func asyncWorker(store *sstore.SStore, s *stats, payload []byte, cfg config, next func() (int64, bool)) {
+	var wg sync.WaitGroup
+	tokens := make(chan struct{}, cfg.inflight)
+	for {
+		i, ok := next()
+		if ok == false {
+			break
+		}
+		tokens <- struct{}{}
+		wg.Add(1)
+		begin := time.Now()
+		store.AsyncAppendWithResult(i%int64(cfg.streams), payload, -1, func(result sstore.AppendResult, err error) {
+			s.ack(result, time.Since(begin), err)
+			<-tokens
+			wg.Done()
+		})
+	}
+	wg.Wait()
+}
+
+//follow read the streams of the reader while they are written
+func follow(store *sstore.SStore, s *stats, cfg config, r int, done chan interface{}) {
+	var offsets = map[int64]int64{}
+	var buf = make([]byte, 64*sstore.KB)
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		var read int64
+		for streamID := int64(r); streamID < int64(cfg.streams); streamID += int64(cfg.readers) {
+			reader, err := store.Reader(streamID)
+			if err != nil {
+				continue
+			}
+			if _, err := reader.Seek(offsets[streamID], io.SeekStart); err != nil {
+				continue
+			}
+			for {
+				n, err := reader.Read(buf)
+				read += int64(n)
+				offsets[streamID] += int64(n)
+				if err != nil || n == 0 {
+					break
+				}
+			}
+		}
+		atomic.AddInt64(&s.readBytes, read)
+		if read == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+//watchStalls record the periods without any ack while writing
+func watchStalls(s *stats, cfg config, done chan interface{}) {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	var stalled time.Duration
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			gap := now.Sub(time.Unix(0, atomic.LoadInt64(&s.lastAck)))
+			if gap >= cfg.stall {
+				stalled = gap
+				continue
+			}
+			if stalled > 0 {
+				s.l.Lock()
+				s.stalls = append(s.stalls, stalled)
+				s.l.Unlock()
+				stalled = 0
+			}
+		}
+	}
+}
+
+func runGC(store *sstore.SStore, s *stats, cfg config, done chan interface{}) {
+	ticker := time.NewTicker(cfg.gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			begin := time.Now()
+			if err := store.GC(); err != nil {
+				fmt.Fprintf(os.Stderr, "GC failed %+v\n", err)
+			}
+			s.l.Lock()
+			s.gcDurations = append(s.gcDurations, time.Since(begin))
+			s.l.Unlock()
+		}
+	}
+}
+
+//writtenBytes return the bytes of the segments flushed and the journals written,
+//the journals not rotated are counted by the size of them after close
+func writtenBytes(cfg config, s *stats) int64 {
+	s.l.Lock()
+	defer s.l.Unlock()
+	written := s.flushedBytes + s.walBytes
+	journals, _ := filepath.Glob(filepath.Join(cfg.options.WalDir, "*"))
+	for _, filename := range journals {
+		if s.rotatedWals[filepath.Base(filename)] {
+			continue
+		}
+		if info, err := os.Stat(filename); err == nil {
+			written += info.Size()
+		}
+	}
+	return written
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() == false {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	index := int(float64(len(durations)-1) * p)
+	return durations[index]
+}
+
+func summary(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "count 0"
+	}
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+	return fmt.Sprintf("count %d total %v p50 %v p99 %v max %v",
+		len(durations), total, percentile(durations, 0.5),
+		percentile(durations, 0.99), durations[len(durations)-1])
+}
+
+func report(cfg config, s *stats, elapsed time.Duration, written int64) {
+	latencies := s.latencies
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	ops := int64(len(latencies))
+	payloadBytes := ops * int64(cfg.size)
+	seconds := elapsed.Seconds()
+	mode := "sync"
+	if cfg.async {
+		mode = "async"
+	}
+	fmt.Printf("workload    %s streams %d size %d concurrency %d readers %d\n",
+		mode, cfg.streams, cfg.size, cfg.concurrency, cfg.readers)
+	fmt.Printf("options     max_table_size %d block_size %d entry_queue_cap %d max_wal_size %d\n",
+		cfg.options.MaxMStreamTableSize, cfg.options.BlockSize,
+		cfg.options.EntryQueueCap, cfg.options.MaxWalSize)
+	fmt.Printf("appends     %d in %v errors %d\n", ops, elapsed, s.errors)
+	fmt.Printf("throughput  %.0f ops/s %.2f MB/s\n",
+		float64(ops)/seconds, float64(payloadBytes)/seconds/sstore.MB)
+	fmt.Printf("latency     p50 %v p90 %v p99 %v p999 %v max %v\n",
+		percentile(latencies, 0.5), percentile(latencies, 0.9),
+		percentile(latencies, 0.99), percentile(latencies, 0.999),
+		percentile(latencies, 1))
+	if cfg.readers > 0 {
+		fmt.Printf("read        %.2f MB/s\n", float64(s.readBytes)/seconds/sstore.MB)
+	}
+	fmt.Printf("stalls      %s\n", summary(s.stalls))
+	fmt.Printf("gc          %s\n", summary(s.gcDurations))
+	fmt.Printf("flush       %s\n", summary(s.flushDurations))
+	if backlogs := s.flushBacklogs; len(backlogs) > 0 {
+		sort.Slice(backlogs, func(i, j int) bool {
+			return backlogs[i] < backlogs[j]
+		})
+		fmt.Printf("flush lag   p50 %d p99 %d max %d entries\n",
+			backlogs[int(float64(len(backlogs)-1)*0.5)],
+			backlogs[int(float64(len(backlogs)-1)*0.99)],
+			backlogs[len(backlogs)-1])
+	}
+	segments, _ := filepath.Glob(filepath.Join(cfg.options.SegmentDir, "*.seg"))
+	fmt.Printf("disk        %d bytes %d segments\n", dirSize(cfg.dir), len(segments))
+	if payloadBytes > 0 {
+		fmt.Printf("write amp   %.2f (%d bytes written)\n",
+			float64(written)/float64(payloadBytes), written)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//sstore-tail print the bytes of a stream to stdout
+//
+//	sstore-tail -dir X -stream N [-from OFFSET] [-follow]
+//
+//with -follow it keeps printing the data appended to the stream,like tail -f
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/akzj/sstore"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	dir := flag.String("dir", "", "directory of the store")
+	streamID := flag.Int64("stream", 0, "stream id")
+	from := flag.Int64("from", -1, "offset to start from,-1 means the begin of stream")
+	follow := flag.Bool("follow", false, "keep printing the data appended to the stream")
+	readOnly := flag.Bool("readonly", true, "open the store read only,so it can run next to the writer")
+	refresh := flag.Duration("refresh", time.Second, "interval to pick up the data of the writer in read only mode")
+	flag.Parse()
+
+	if *dir == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := tail(*dir, *streamID, *from, *follow, *readOnly, *refresh); err != nil {
+		fmt.Fprintf(os.Stderr, "sstore-tail: %+v\n", err)
+		os.Exit(1)
+	}
+}
+
+func tail(dir string, streamID int64, from int64, follow bool, readOnly bool, refresh time.Duration) error {
+	options := sstore.DefaultOptions(dir).WithReadOnly(readOnly)
+	if follow && readOnly {
+		options = options.WithRefreshInterval(refresh)
+	}
+	store, err := sstore.Open(options)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	//watch before the first read,so no append is missed
+	watcher := store.Watcher(streamID)
+	defer watcher.Close()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	var reader io.ReadSeeker
+	for reader == nil {
+		reader, err = store.Reader(streamID)
+		if err != nil {
+			if errors.Cause(err) != sstore.ErrNoFindStream || follow == false {
+				return err
+			}
+			select {
+			case <-watcher.Watch():
+			case <-signals:
+				return nil
+			}
+		}
+	}
+	if from >= 0 {
+		if _, err := reader.Seek(from, io.SeekStart); err != nil {
+			return errors.Wrapf(err, "seek to %d", from)
+		}
+	} else if begin, ok := store.Begin(streamID); ok {
+		if _, err := reader.Seek(begin, io.SeekStart); err != nil {
+			return errors.Wrapf(err, "seek to %d", begin)
+		}
+	}
+	for {
+		if _, err := io.Copy(os.Stdout, reader); err != nil {
+			return err
+		}
+		if follow == false {
+			return nil
+		}
+		select {
+		case <-watcher.Watch():
+		case <-signals:
+			return nil
+		}
+	}
+}
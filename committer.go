@@ -15,8 +15,11 @@ package sstore
 
 import (
 	"log"
+	"math"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type committer struct {
@@ -40,9 +43,19 @@ type committer struct {
 	files       *manifest
 
 	blockSize int
+	readOnly  bool
+	//lastEntryID is the ID of last entry applied
+	lastEntryID int64
 
 	cbWorker      *cbWorker
 	callbackQueue *entryQueue
+	commitNotify  *commitNotify
+	recentEntries *entryRing
+	producers     *producerTable
+	epochs        *epochTable
+	modes         *modeTable
+	consumers     *consumerTable
+	listener      EventListener
 }
 
 func newCommitter(options Options,
@@ -53,6 +66,7 @@ func newCommitter(options Options,
 	mutableMStreamMap *mStreamTable,
 	queue *entryQueue,
 	files *manifest,
+	commitNotify *commitNotify,
 	blockSize int) *committer {
 
 	cbQueue := newEntryQueue(128)
@@ -61,6 +75,7 @@ func newCommitter(options Options,
 		files:                         files,
 		queue:                         queue,
 		blockSize:                     blockSize,
+		readOnly:                      options.ReadOnly,
 		maxMStreamTableSize:           options.MaxMStreamTableSize,
 		mutableMStreamMap:             mutableMStreamMap,
 		sizeMap:                       sizeMap,
@@ -74,6 +89,13 @@ func newCommitter(options Options,
 		maxImmutableMStreamTableCount: options.MaxImmutableMStreamTableCount,
 		cbWorker:                      newCbWorker(cbQueue),
 		callbackQueue:                 cbQueue,
+		commitNotify:                  commitNotify,
+		recentEntries:                 newEntryRing(recentEntriesCap),
+		producers:                     newProducerTable(),
+		epochs:                        newEpochTable(),
+		modes:                         newModeTable(),
+		consumers:                     newConsumerTable(),
+		listener:                      options.eventListener(),
 	}
 }
 
@@ -87,6 +109,16 @@ func (c *committer) appendSegment(filename string, segment *segment) {
 	}
 }
 
+//appendCompacted index the compacted segment instead of the data it replaces,
+//it returns the filenames of the compacted segments replaced
+func (c *committer) appendCompacted(filename string, segment *segment) ([]string, error) {
+	c.segmentsLocker.Lock()
+	defer c.segmentsLocker.Unlock()
+	segment.refInc()
+	c.segments[filepath.Base(filename)] = segment
+	return c.indexTable.compact(segment)
+}
+
 func (c *committer) getSegment(filename string) *segment {
 	c.segmentsLocker.Lock()
 	defer c.segmentsLocker.Unlock()
@@ -138,17 +170,64 @@ func (c *committer) flushCallback(filename string, _ *mStreamTable) {
 
 func (c *committer) flush() {
 	mStreamMap := c.mutableMStreamMap
-	c.mutableMStreamMap = newMStreamTable(c.sizeMap, c.blockSize,
+	mStreamMap.producers = c.producers.clone()
+	mStreamMap.epochs = c.epochs.clone()
+	mStreamMap.modes = c.modes.clone()
+	mStreamMap.consumers = c.consumers.clone()
+	c.mutableMStreamMap = newMStreamTable(c.sizeMap, mStreamMap.recordMap, c.blockSize,
 		len(c.mutableMStreamMap.mStreams))
 	c.locker.Lock()
 	c.immutableMStreamMaps = append(c.immutableMStreamMaps, mStreamMap)
 	c.locker.Unlock()
+	frozen := time.Now()
 	c.flusher.append(mStreamMap, func(filename string, err error) {
 		if err != nil {
 			log.Fatal(err.Error())
 		}
 		c.flushCallback(filename, mStreamMap)
+		segment := c.getSegment(filepath.Base(filename))
+		c.listener.OnFlush(FlushEvent{
+			Filename:    filepath.Base(filename),
+			Size:        fileSize(filename),
+			LastEntryID: segment.lastEntryID(),
+			Duration:    time.Since(frozen),
+			Streams:     segmentStreams(segment),
+		})
+	})
+}
+
+//barrierSignal is the ID of entry which runs its callback in the committer
+//after the entries queued before it are applied
+const barrierSignal = math.MinInt64 + 1
+
+//barrier wait for the committer to apply the entries queued before,
+//then run f in the committer,no entry is applied while f is running
+func (c *committer) barrier(f func()) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	c.queue.put(&entry{
+		ID: barrierSignal,
+		cb: func(_ int64, err error) {
+			if f != nil {
+				f()
+			}
+			wg.Done()
+		},
+	})
+	wg.Wait()
+}
+
+//close the committer of read only store,it has no wWriter to close it
+func (c *committer) close() {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	c.queue.put(&entry{
+		ID: closeSignal,
+		cb: func(_ int64, err error) {
+			wg.Done()
+		},
 	})
+	wg.Wait()
 }
 
 func (c *committer) start() {
@@ -161,27 +240,190 @@ func (c *committer) start() {
 				e := entries[i]
 				if e.ID == closeSignal {
 					c.flusher.close()
-					c.callbackQueue.put(e)
+					c.callbackQueue.putEntries(entries[:i+1])
 					return
 				}
-				mStream, end := c.mutableMStreamMap.appendEntry(e)
-				if end == -1 {
-					e.err = ErrOffset
+				if e.ID == barrierSignal {
+					e.cb(0, nil)
 					continue
 				}
-				e.end = end
-				if mStream != nil {
-					c.indexTable.update(mStream)
+				if e.StreamID == epochStreamID {
+					c.applyEpoch(e)
+				} else if e.StreamID == consumerStreamID {
+					c.applyConsumer(e)
+				} else if e.isBatch() {
+					c.applyBatch(e)
+				} else {
+					c.applyEntry(e)
 				}
-				item := notifyPool.Get().(*notify)
-				item.streamID = e.StreamID
-				item.end = end
-				c.endWatchers.notify(item)
-				if c.mutableMStreamMap.mSize >= c.maxMStreamTableSize {
+				atomic.StoreInt64(&c.lastEntryID, e.ID)
+				//a read only store never writes segments
+				if c.readOnly == false &&
+					c.mutableMStreamMap.mSize >= c.maxMStreamTableSize {
 					c.flush()
 				}
 			}
 			c.callbackQueue.putEntries(entries)
+			c.commitNotify.notify()
 		}
 	}()
 }
+
+//applyEntry append the entry to the mStream and make it readable
+func (c *committer) applyEntry(e *entry) {
+	switch e.Offset {
+	case offsetFenced:
+		e.err = ErrFenced
+		c.recentEntries.append(e, -1)
+		return
+	case offsetWrongMode:
+		e.err = ErrStreamMode
+		c.recentEntries.append(e, -1)
+		return
+	}
+	mStream, end := c.mutableMStreamMap.appendEntry(e)
+	if end == -1 {
+		e.err = ErrOffset
+		c.recentEntries.append(e, end)
+		return
+	}
+	e.end = end
+	if mStream != nil {
+		c.indexTable.update(mStream)
+	}
+	//the data is readable now
+	c.recentEntries.append(e, end)
+	item := notifyPool.Get().(*notify)
+	item.streamID = e.StreamID
+	item.end = end
+	c.endWatchers.notify(item)
+}
+
+//applyBatch append the entries of batch,none of them is appended
+//if the offset of any one is wrong
+func (c *committer) applyBatch(e *entry) {
+	batch, err := e.batchEntries()
+	if err != nil {
+		e.err = err
+		c.recentEntries.append(e, -1)
+		return
+	}
+	switch e.Offset {
+	case offsetDuplicate:
+		//acknowledge the retried append with the results of the original one
+		e.err = ErrDuplicate
+		if results := c.producers.results(e.producerID, e.seq); len(results) == len(batch) {
+			e.err = nil
+			for i, it := range batch {
+				it.ID = results[i].EntryID
+				it.Offset = results[i].Begin
+				it.end = results[i].End
+			}
+		}
+		c.recentEntries.append(e, -1)
+		return
+	case offsetOutOfSequence:
+		e.err = ErrSequence
+		c.recentEntries.append(e, -1)
+		return
+	case offsetFenced:
+		e.err = ErrFenced
+		c.recentEntries.append(e, -1)
+		return
+	case offsetWrongMode:
+		e.err = ErrStreamMode
+		c.recentEntries.append(e, -1)
+		return
+	}
+	if e.StreamID == fencedStreamID {
+		for _, it := range batch {
+			if e.epoch < c.epochs.get(it.StreamID) {
+				e.err = ErrFenced
+				c.recentEntries.append(e, -1)
+				return
+			}
+		}
+	}
+	mStreams, ok := c.mutableMStreamMap.appendBatch(e.Offset, batch)
+	if ok == false {
+		e.err = ErrOffset
+		c.recentEntries.append(e, -1)
+		return
+	}
+	for _, mStream := range mStreams {
+		c.indexTable.update(mStream)
+	}
+	if e.StreamID == recordStreamID {
+		for _, it := range batch {
+			c.modes.set(it.StreamID, e.mode)
+		}
+	}
+	if e.StreamID == producerStreamID {
+		var results = make([]AppendResult, 0, len(batch))
+		for _, it := range batch {
+			results = append(results, AppendResult{
+				EntryID: it.ID,
+				Begin:   it.Offset,
+				End:     it.end,
+			})
+		}
+		c.producers.commit(e.producerID, e.seq, results)
+	}
+	for _, it := range batch {
+		c.recentEntries.append(it, it.end)
+		item := notifyPool.Get().(*notify)
+		item.streamID = it.StreamID
+		item.end = it.end
+		c.endWatchers.notify(item)
+	}
+}
+
+//applyEpoch set the epoch of stream,the older epoch is rejected
+func (c *committer) applyEpoch(e *entry) {
+	c.recentEntries.append(e, -1)
+	streamID, epoch, err := e.decodeEpoch()
+	if err != nil {
+		e.err = err
+		return
+	}
+	if epoch < c.epochs.get(streamID) {
+		e.err = ErrFenced
+		return
+	}
+	c.epochs.set(streamID, epoch)
+}
+
+//applyConsumer set the offset of consumer
+func (c *committer) applyConsumer(e *entry) {
+	c.recentEntries.append(e, -1)
+	consumer, streamID, offset, err := e.decodeConsumer()
+	if err != nil {
+		e.err = err
+		return
+	}
+	c.consumers.set(consumer, streamID, offset)
+}
+
+//commitNotify wake up the goroutines waiting for the entries committed
+type commitNotify struct {
+	l sync.Mutex
+	c chan interface{}
+}
+
+func newCommitNotify() *commitNotify {
+	return &commitNotify{c: make(chan interface{})}
+}
+
+//wait return the chan closed at the next commit
+func (n *commitNotify) wait() <-chan interface{} {
+	n.l.Lock()
+	defer n.l.Unlock()
+	return n.c
+}
+
+func (n *commitNotify) notify() {
+	n.l.Lock()
+	defer n.l.Unlock()
+	close(n.c)
+	n.c = make(chan interface{})
+}
@@ -0,0 +1,339 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+//the flag of keyed record,the payload of it is flag,key length,key and value
+const (
+	keyedValue     = 0
+	keyedTombstone = 1
+	keyedHeader    = 5
+)
+
+//KeyedRecord is a record of the key-compacted stream
+type KeyedRecord struct {
+	Key   []byte
+	Value []byte
+	//Tombstone is true if the record deletes the key
+	Tombstone bool
+	//Begin,End are the offsets of the frame of record in the stream
+	Begin int64
+	End   int64
+}
+
+func encodeKeyed(flag byte, key []byte, value []byte) []byte {
+	var data = make([]byte, keyedHeader+len(key)+len(value))
+	data[0] = flag
+	binary.BigEndian.PutUint32(data[1:], uint32(len(key)))
+	copy(data[keyedHeader:], key)
+	copy(data[keyedHeader+len(key):], value)
+	return data
+}
+
+func decodeKeyed(record *Record) (*KeyedRecord, error) {
+	data := record.Data
+	if len(data) < keyedHeader || data[0] > keyedTombstone ||
+		int(binary.BigEndian.Uint32(data[1:])) > len(data)-keyedHeader {
+		return nil, errors.WithMessage(ErrRecord,
+			fmt.Sprintf("offset[%d] is not keyed record", record.Begin))
+	}
+	keyEnd := keyedHeader + int(binary.BigEndian.Uint32(data[1:]))
+	return &KeyedRecord{
+		Key:       data[keyedHeader:keyEnd],
+		Value:     data[keyEnd:],
+		Tombstone: data[0] == keyedTombstone,
+		Begin:     record.Begin,
+		End:       record.End,
+	}, nil
+}
+
+//AppendKeyed append the value of key to the key-compacted stream,the stream is
+//key-compacted after the first keyed record appended,the other appends to it
+//are rejected with ErrStreamMode.the stream with other data can't be key-compacted
+func (sstore *SStore) AppendKeyed(streamID int64, key []byte, value []byte) (AppendResult, error) {
+	return sstore.appendKeyed(streamID, encodeKeyed(keyedValue, key, value))
+}
+
+//DeleteKey append the tombstone of key to the key-compacted stream,
+//the records of key before it are deleted by compaction
+func (sstore *SStore) DeleteKey(streamID int64, key []byte) (AppendResult, error) {
+	return sstore.appendKeyed(streamID, encodeKeyed(keyedTombstone, key, nil))
+}
+
+func (sstore *SStore) appendKeyed(streamID int64, data []byte) (AppendResult, error) {
+	var err error
+	var result AppendResult
+	sstore.syncCall(func(done func()) {
+		sstore.asyncAppendFrame(streamModeKeyed, streamID, encodeRecord(data), -1,
+			func(r AppendResult, e error) {
+				result, err = r, e
+				done()
+			})
+	})
+	return result, err
+}
+
+//KeyedReader read the keyed records of the key-compacted stream
+type KeyedReader struct {
+	*RecordReader
+}
+
+//KeyedReader create KeyedReader of the stream,it reads from the begin of stream
+func (sstore *SStore) KeyedReader(streamID int64) (*KeyedReader, error) {
+	reader, err := sstore.RecordReader(streamID)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyedReader{RecordReader: reader}, nil
+}
+
+//Next return the next keyed record,it returns io.EOF at the end of stream
+func (kr *KeyedReader) Next() (*KeyedRecord, error) {
+	record, err := kr.RecordReader.Next()
+	if err != nil {
+		return nil, err
+	}
+	return decodeKeyed(record)
+}
+
+//keyedOffset is the last record of key before compaction
+type keyedOffset struct {
+	begin     int64
+	end       int64
+	tombstone bool
+}
+
+//CompactStream rewrite the data of the key-compacted stream in the segments,
+//only the last record of each key is kept.the tombstones are kept by the first
+//compaction,so the readers can see the keys deleted,and dropped by the next one.
+//the records kept keep the offsets and sequences of them,the gaps of the records
+//deleted are skipped by the readers,so the consumer offsets,the record and time
+//index stay valid.the readers in the data compacted read the segments replaced
+//until they read past them.it returns ErrStreamMode if the stream is not key-compacted
+func (sstore *SStore) CompactStream(streamID int64) error {
+	if sstore.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if sstore.committer.modes.get(streamID) != streamModeKeyed {
+		return errors.Wrapf(ErrStreamMode, "stream[%d] is not key-compacted", streamID)
+	}
+	//GC must not delete the segments being compacted
+	sstore.gcLocker.Lock()
+	defer sstore.gcLocker.Unlock()
+	offsetIndex := sstore.indexTable.get(streamID)
+	if offsetIndex == nil {
+		return errors.Wrapf(ErrNoFindStream, "stream[%d]", streamID)
+	}
+	items := offsetIndex.flushedItems()
+	if len(items) == 0 {
+		return nil
+	}
+	begin, end := items[0].begin, items[len(items)-1].end
+	//the tombstones were kept by the compaction before
+	var compactedEnd = begin
+	if items[0].segment.meta.Compacted {
+		compactedEnd = items[0].end
+	}
+
+	var keys = make(map[string]keyedOffset)
+	var count int64
+	if err := sstore.scanKeyed(streamID, begin, end, func(_ int64, record *KeyedRecord) error {
+		count++
+		keys[string(record.Key)] = keyedOffset{
+			begin:     record.Begin,
+			end:       record.End,
+			tombstone: record.Tombstone,
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	var keep = make(map[int64]bool, len(keys))
+	for _, it := range keys {
+		if it.tombstone && it.begin < compactedEnd {
+			continue
+		}
+		keep[it.begin] = true
+	}
+	if int64(len(keep)) == count {
+		return nil
+	}
+
+	filename := sstore.files.getNextSegment()
+	segment, err := sstore.writeCompacted(filename, streamID, items, keep)
+	if err != nil {
+		_ = os.Remove(filename)
+		return err
+	}
+	if err := sstore.files.appendSegment(appendSegment{Filename: filename}); err != nil {
+		_ = segment.close()
+		return err
+	}
+	replaced, err := sstore.committer.appendCompacted(filename, segment)
+	if err != nil {
+		return err
+	}
+	//the compacted segments replaced have no data indexed,
+	//the readers in them hold them until they read past
+	for _, filename := range replaced {
+		if err := sstore.committer.getSegment(filename).deleteOnClose(true); err != nil {
+			return err
+		}
+		if err := sstore.committer.deleteSegment(filename); err != nil {
+			return err
+		}
+		if err := sstore.files.deleteSegment(deleteSegment{Filename: filename}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//scanKeyed pass the keyed records of stream in [begin,end) with the sequences to cb
+func (sstore *SStore) scanKeyed(streamID int64, begin int64, end int64,
+	cb func(seq int64, record *KeyedRecord) error) error {
+	reader, err := sstore.KeyedReader(streamID)
+	if err != nil {
+		return err
+	}
+	if err := reader.seek(begin); err != nil {
+		return err
+	}
+	var seq int64
+	var offset = int64(-1)
+	for reader.Offset() < end {
+		record, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				return errors.WithStack(io.ErrUnexpectedEOF)
+			}
+			return err
+		}
+		if record.Begin >= end {
+			//the record after the gap at the end
+			return nil
+		}
+		//the sequence is not counted over the gap deleted by compaction
+		if record.Begin != offset {
+			if seq, _, err = sstore.RecordAt(streamID, record.Begin); err != nil {
+				return err
+			}
+		}
+		if err := cb(seq, record); err != nil {
+			return err
+		}
+		seq++
+		offset = record.End
+	}
+	return nil
+}
+
+//writeCompacted write the records of stream kept to the segment,the offsets of
+//them are kept and the records between them are the gaps of extents
+func (sstore *SStore) writeCompacted(filename string, streamID int64, items []offsetItem,
+	keep map[int64]bool) (*segment, error) {
+	last := items[len(items)-1].segment
+	lastInfo := last.meta.OffSetInfos[streamID]
+	var info = offsetInfo{
+		StreamID: streamID,
+		Begin:    items[0].begin,
+		End:      lastInfo.End,
+		Records:  lastInfo.Records,
+	}
+	//the offsets of time index are kept too
+	for _, item := range items {
+		it := item.segment.meta.OffSetInfos[streamID]
+		for _, index := range it.TimeIndex {
+			if index.Offset >= info.Begin {
+				info.TimeIndex = append(info.TimeIndex, index)
+			}
+		}
+		if it.LastTS > info.LastTS {
+			info.LastTS = it.LastTS
+		}
+	}
+
+	segment, err := createSegment(filename)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	writer := bufio.NewWriterSize(segment.f, 1024*1024)
+	hash := crc32.NewIEEE()
+	mWriter := io.MultiWriter(writer, hash)
+	var pos int64
+	err = sstore.scanKeyed(streamID, info.Begin, info.End, func(seq int64, record *KeyedRecord) error {
+		if keep[record.Begin] == false {
+			return nil
+		}
+		n := len(info.Extents)
+		if n == 0 || info.Extents[n-1].End != record.Begin {
+			info.Extents = append(info.Extents, offsetExtent{Begin: record.Begin, End: record.End, Pos: pos})
+			//the sequence of the record after the gap is indexed
+			info.RecordIndex = append(info.RecordIndex, recordIndexItem{Seq: seq, Offset: record.Begin})
+		} else {
+			info.Extents[n-1].End = record.End
+			if seq%recordIndexInterval == 0 {
+				info.RecordIndex = append(info.RecordIndex, recordIndexItem{Seq: seq, Offset: record.Begin})
+			}
+		}
+		frame := encodeRecord(encodeKeyed(keyedFlag(record), record.Key, record.Value))
+		if int64(len(frame)) != record.End-record.Begin {
+			return errors.Errorf("compact stream[%d] offset[%d] frame size error", streamID, record.Begin)
+		}
+		if _, err := mWriter.Write(frame); err != nil {
+			return errors.WithStack(err)
+		}
+		pos += int64(len(frame))
+		return nil
+	})
+	if err != nil {
+		_ = segment.close()
+		return nil, err
+	}
+	if len(info.Extents) == 0 {
+		//all the records are deleted,the empty extent makes [Begin,End) a gap
+		info.Extents = []offsetExtent{{Begin: info.End, End: info.End}}
+	}
+	info.CRC = hash.Sum32()
+	segment.meta.OffSetInfos[streamID] = info
+	segment.meta.Ver = last.meta.Ver
+	segment.meta.GcTS = time.Now()
+	segment.meta.LastEntryID = last.meta.LastEntryID
+	segment.meta.Compacted = true
+	if err := segment.writeMeta(writer); err != nil {
+		_ = segment.close()
+		return nil, errors.WithStack(err)
+	}
+	if err := segment.close(); err != nil {
+		return nil, err
+	}
+	return openSegment(filename)
+}
+
+func keyedFlag(record *KeyedRecord) byte {
+	if record.Tombstone {
+		return keyedTombstone
+	}
+	return keyedValue
+}
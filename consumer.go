@@ -0,0 +1,210 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+//queueConsumer is the consumer pinning the checkpoints of the state streams of queues,
+//GC keeps the segments holding the state after them whatever ConsumerRetention is
+const queueConsumer = "sstore.queue"
+
+//consumerTable is the offsets of consumers by name and StreamID
+type consumerTable struct {
+	l         sync.Mutex
+	consumers map[string]map[int64]int64
+}
+
+func newConsumerTable() *consumerTable {
+	return &consumerTable{
+		consumers: make(map[string]map[int64]int64),
+	}
+}
+
+func (table *consumerTable) get(consumer string, streamID int64) (int64, bool) {
+	table.l.Lock()
+	defer table.l.Unlock()
+	offset, ok := table.consumers[consumer][streamID]
+	return offset, ok
+}
+
+func (table *consumerTable) set(consumer string, streamID int64, offset int64) {
+	table.l.Lock()
+	defer table.l.Unlock()
+	if offset == consumerDeleted {
+		delete(table.consumers, consumer)
+		return
+	}
+	offsets, ok := table.consumers[consumer]
+	if ok == false {
+		offsets = make(map[int64]int64)
+		table.consumers[consumer] = offsets
+	}
+	offsets[streamID] = offset
+}
+
+//names return the names of consumers in order,the reserved queueConsumer is not listed
+func (table *consumerTable) names() []string {
+	table.l.Lock()
+	defer table.l.Unlock()
+	var names = make([]string, 0, len(table.consumers))
+	for name := range table.consumers {
+		if name == queueConsumer {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//offsets return the copy of the offsets of consumer,
+//it is empty for the reserved queueConsumer
+func (table *consumerTable) offsets(consumer string) map[int64]int64 {
+	table.l.Lock()
+	defer table.l.Unlock()
+	if consumer == queueConsumer {
+		return map[int64]int64{}
+	}
+	var offsets = make(map[int64]int64, len(table.consumers[consumer]))
+	for streamID, offset := range table.consumers[consumer] {
+		offsets[streamID] = offset
+	}
+	return offsets
+}
+
+//unread return true if any consumer of the stream has not read to end
+func (table *consumerTable) unread(streamID int64, end int64) bool {
+	table.l.Lock()
+	defer table.l.Unlock()
+	for _, offsets := range table.consumers {
+		if offset, ok := offsets[streamID]; ok && offset < end {
+			return true
+		}
+	}
+	return false
+}
+
+//pinned return true if the queue has not checkpointed its state past end
+func (table *consumerTable) pinned(streamID int64, end int64) bool {
+	table.l.Lock()
+	defer table.l.Unlock()
+	offset, ok := table.consumers[queueConsumer][streamID]
+	return ok && offset < end
+}
+
+//clone return the copy of consumers to persist
+func (table *consumerTable) clone() map[string]map[int64]int64 {
+	table.l.Lock()
+	defer table.l.Unlock()
+	return cloneConsumers(table.consumers)
+}
+
+//load the consumers persisted
+func (table *consumerTable) load(consumers map[string]map[int64]int64) {
+	table.l.Lock()
+	defer table.l.Unlock()
+	table.consumers = cloneConsumers(consumers)
+}
+
+func cloneConsumers(consumers map[string]map[int64]int64) map[string]map[int64]int64 {
+	var clone = make(map[string]map[int64]int64, len(consumers))
+	for name, offsets := range consumers {
+		var offsetsClone = make(map[int64]int64, len(offsets))
+		for streamID, offset := range offsets {
+			offsetsClone[streamID] = offset
+		}
+		clone[name] = offsetsClone
+	}
+	return clone
+}
+
+//CommitOffset commit the offset of stream read by the consumer,
+//the consumer is registered by its first commit
+func (sstore *SStore) CommitOffset(consumer string, streamID int64, offset int64) error {
+	if consumer == "" {
+		return errors.Errorf("consumer name is empty")
+	}
+	if consumer == queueConsumer {
+		return errors.Errorf("consumer[%s] is reserved", consumer)
+	}
+	if offset < 0 {
+		return errors.Wrapf(ErrOffset, "offset[%d]", offset)
+	}
+	if err := checkStreamID(streamID); err != nil {
+		return err
+	}
+	return sstore.putConsumer(consumer, streamID, offset)
+}
+
+//DeleteConsumer delete the consumer and all the offsets of it
+func (sstore *SStore) DeleteConsumer(consumer string) error {
+	if consumer == queueConsumer {
+		return errors.Errorf("consumer[%s] is reserved", consumer)
+	}
+	return sstore.putConsumer(consumer, 0, consumerDeleted)
+}
+
+func (sstore *SStore) putConsumer(consumer string, streamID int64, offset int64) error {
+	var buffer bytes.Buffer
+	_ = binary.Write(&buffer, binary.BigEndian, streamID)
+	_ = binary.Write(&buffer, binary.BigEndian, offset)
+	buffer.WriteString(consumer)
+	var err error
+	sstore.syncCall(func(done func()) {
+		sstore.putEntry(&entry{
+			StreamID: consumerStreamID,
+			Offset:   -1,
+			data:     buffer.Bytes(),
+			cb: func(_ int64, e error) {
+				err = e
+				done()
+			},
+		})
+	})
+	return err
+}
+
+//GetOffset return the offset of stream committed by the consumer,
+//false if it is never committed
+func (sstore *SStore) GetOffset(consumer string, streamID int64) (int64, bool) {
+	return sstore.committer.consumers.get(consumer, streamID)
+}
+
+//Consumers return the names of registered consumers
+func (sstore *SStore) Consumers() []string {
+	return sstore.committer.consumers.names()
+}
+
+//ConsumerOffsets return the offsets of streams committed by the consumer
+func (sstore *SStore) ConsumerOffsets(consumer string) map[int64]int64 {
+	return sstore.committer.consumers.offsets(consumer)
+}
+
+//decodeConsumer return the name of consumer,StreamID and offset
+//of the entry committing offset
+func (e *entry) decodeConsumer() (string, int64, int64, error) {
+	if len(e.data) < 16 {
+		return "", 0, 0, errors.WithStack(io.ErrUnexpectedEOF)
+	}
+	return string(e.data[16:]),
+		int64(binary.BigEndian.Uint64(e.data)),
+		int64(binary.BigEndian.Uint64(e.data[8:])), nil
+}
@@ -1,11 +1,13 @@
 package sstore
 
 import (
+	"container/heap"
 	"sync"
 )
 
 type endWatcher struct {
 	index  int64
+	end    int64
 	c      func()
 	int64s chan int64
 }
@@ -15,10 +17,55 @@ type notify struct {
 	end      int64
 }
 
+//endWaiter wait for the end of stream reaching end,
+//c is closed when it is reached or the endWatchers closed
+type endWaiter struct {
+	streamID int64
+	end      int64
+	index    int
+	err      error
+	c        chan interface{}
+}
+
+//endWaiterHeap is the min heap of waiters by end,
+//the waiters reached are popped without visiting the others
+type endWaiterHeap []*endWaiter
+
+func (h endWaiterHeap) Len() int           { return len(h) }
+func (h endWaiterHeap) Less(i, j int) bool { return h[i].end < h[j].end }
+func (h endWaiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *endWaiterHeap) Push(x interface{}) {
+	waiter := x.(*endWaiter)
+	waiter.index = len(*h)
+	*h = append(*h, waiter)
+}
+
+func (h *endWaiterHeap) Pop() interface{} {
+	old := *h
+	waiter := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	waiter.index = -1
+	return waiter
+}
+
 type endWatchers struct {
-	watchIndex     int64
-	endWatcherMap  map[int64][]endWatcher
-	endWatcherLock *sync.RWMutex
+	watchIndex    int64
+	endWatcherMap map[int64][]endWatcher
+	endWaiterMap  map[int64]*endWaiterHeap
+	//multiWatcherMap is the MultiWatchers of stream sets by stream ID,
+	//rangeWatchers are the MultiWatchers of ranges and all streams
+	multiWatcherMap map[int64][]*MultiWatcher
+	rangeWatchers   []*MultiWatcher
+	endWatcherLock  *sync.RWMutex
+	//closed is true after the waiters and MultiWatchers closed,
+	//the new ones are closed at once
+	closed bool
 
 	l           *sync.Mutex
 	cond        *sync.Cond
@@ -33,13 +80,15 @@ var notifyPool = sync.Pool{New: func() interface{} {
 func newEndWatchers() *endWatchers {
 	l := new(sync.Mutex)
 	return &endWatchers{
-		watchIndex:     0,
-		l:              l,
-		cond:           sync.NewCond(l),
-		endWatcherLock: new(sync.RWMutex),
-		notifyItems:    make([]*notify, 0, 1024),
-		endWatcherMap:  make(map[int64][]endWatcher),
-		s:              make(chan interface{}, 1),
+		watchIndex:      0,
+		l:               l,
+		cond:            sync.NewCond(l),
+		endWatcherLock:  new(sync.RWMutex),
+		notifyItems:     make([]*notify, 0, 1024),
+		endWatcherMap:   make(map[int64][]endWatcher),
+		endWaiterMap:    make(map[int64]*endWaiterHeap),
+		multiWatcherMap: make(map[int64][]*MultiWatcher),
+		s:               make(chan interface{}, 1),
 	}
 }
 func (endWatchers *endWatchers) removeEndWatcher(index int64, streamID int64) {
@@ -60,13 +109,16 @@ func (endWatchers *endWatchers) removeEndWatcher(index int64, streamID int64) {
 	}
 }
 
-func (endWatchers *endWatchers) newEndWatcher(streamID int64) *endWatcher {
+//newEndWatcher create watcher of the stream,the ends not greater than end
+//are ignored,they were committed before the watcher created
+func (endWatchers *endWatchers) newEndWatcher(streamID int64, end int64) *endWatcher {
 	endWatchers.endWatcherLock.Lock()
 	defer endWatchers.endWatcherLock.Unlock()
 	endWatchers.watchIndex++
 	index := endWatchers.watchIndex
 	watcher := endWatcher{
 		index:  index,
+		end:    end,
 		int64s: make(chan int64, 1),
 		c: func() {
 			endWatchers.removeEndWatcher(index, streamID)
@@ -76,6 +128,174 @@ func (endWatchers *endWatchers) newEndWatcher(streamID int64) *endWatcher {
 	return &watcher
 }
 
+//newEndWaiter create the waiter of the stream reaching end,
+//it is woken up with ErrClose if the endWatchers closed
+func (endWatchers *endWatchers) newEndWaiter(streamID int64, end int64) *endWaiter {
+	endWatchers.endWatcherLock.Lock()
+	defer endWatchers.endWatcherLock.Unlock()
+	waiter := &endWaiter{
+		streamID: streamID,
+		end:      end,
+		c:        make(chan interface{}),
+	}
+	if endWatchers.closed {
+		waiter.index = -1
+		waiter.err = ErrClose
+		close(waiter.c)
+		return waiter
+	}
+	waiters, ok := endWatchers.endWaiterMap[streamID]
+	if ok == false {
+		waiters = new(endWaiterHeap)
+		endWatchers.endWaiterMap[streamID] = waiters
+	}
+	heap.Push(waiters, waiter)
+	return waiter
+}
+
+//removeEndWaiter remove the waiter not woken up
+func (endWatchers *endWatchers) removeEndWaiter(waiter *endWaiter) {
+	endWatchers.endWatcherLock.Lock()
+	defer endWatchers.endWatcherLock.Unlock()
+	if waiter.index < 0 {
+		return
+	}
+	waiters := endWatchers.endWaiterMap[waiter.streamID]
+	heap.Remove(waiters, waiter.index)
+	if waiters.Len() == 0 {
+		delete(endWatchers.endWaiterMap, waiter.streamID)
+	}
+}
+
+//wakeEndWaiters wake up the waiters of the stream reached end
+func (endWatchers *endWatchers) wakeEndWaiters(streamID int64, end int64) {
+	endWatchers.endWatcherLock.RLock()
+	waiters, ok := endWatchers.endWaiterMap[streamID]
+	ready := ok && (*waiters)[0].end <= end
+	endWatchers.endWatcherLock.RUnlock()
+	if ready == false {
+		return
+	}
+	endWatchers.endWatcherLock.Lock()
+	defer endWatchers.endWatcherLock.Unlock()
+	waiters, ok = endWatchers.endWaiterMap[streamID]
+	if ok == false {
+		return
+	}
+	for waiters.Len() > 0 && (*waiters)[0].end <= end {
+		close(heap.Pop(waiters).(*endWaiter).c)
+	}
+	if waiters.Len() == 0 {
+		delete(endWatchers.endWaiterMap, streamID)
+	}
+}
+
+//closeEndWaiters wake up all the waiters and the ones created later with ErrClose
+func (endWatchers *endWatchers) closeEndWaiters() {
+	endWatchers.endWatcherLock.Lock()
+	defer endWatchers.endWatcherLock.Unlock()
+	endWatchers.closed = true
+	for streamID, waiters := range endWatchers.endWaiterMap {
+		for waiters.Len() > 0 {
+			waiter := heap.Pop(waiters).(*endWaiter)
+			waiter.err = ErrClose
+			close(waiter.c)
+		}
+		delete(endWatchers.endWaiterMap, streamID)
+	}
+}
+
+func (endWatchers *endWatchers) newMultiWatcher(filter StreamFilter, cb func(StreamEnd)) *MultiWatcher {
+	watcher := &MultiWatcher{
+		endWatchers: endWatchers,
+		filter:      filter,
+		pending:     make(map[int64]int64),
+		signal:      make(chan interface{}, 1),
+		c:           make(chan StreamEnd, 64),
+		cb:          cb,
+		s:           make(chan interface{}),
+		done:        make(chan interface{}),
+	}
+	endWatchers.endWatcherLock.Lock()
+	if endWatchers.closed {
+		endWatchers.endWatcherLock.Unlock()
+		watcher.start()
+		watcher.Close()
+		return watcher
+	}
+	if filter.streamIDs != nil {
+		for _, streamID := range filter.streamIDs {
+			endWatchers.multiWatcherMap[streamID] = append(endWatchers.multiWatcherMap[streamID], watcher)
+		}
+	} else {
+		endWatchers.rangeWatchers = append(endWatchers.rangeWatchers, watcher)
+	}
+	endWatchers.endWatcherLock.Unlock()
+	watcher.start()
+	return watcher
+}
+
+//closeMultiWatchers close all the MultiWatchers,the store closes them
+//if the users don't
+func (endWatchers *endWatchers) closeMultiWatchers() {
+	endWatchers.endWatcherLock.Lock()
+	var watchers = append([]*MultiWatcher(nil), endWatchers.rangeWatchers...)
+	var seen = make(map[*MultiWatcher]bool)
+	for _, it := range endWatchers.multiWatcherMap {
+		for _, watcher := range it {
+			if seen[watcher] == false {
+				seen[watcher] = true
+				watchers = append(watchers, watcher)
+			}
+		}
+	}
+	endWatchers.endWatcherLock.Unlock()
+	for _, watcher := range watchers {
+		watcher.Close()
+	}
+}
+
+func (endWatchers *endWatchers) removeMultiWatcher(watcher *MultiWatcher) {
+	endWatchers.endWatcherLock.Lock()
+	defer endWatchers.endWatcherLock.Unlock()
+	var remove = func(watchers []*MultiWatcher) []*MultiWatcher {
+		for i, it := range watchers {
+			if it == watcher {
+				copy(watchers[i:], watchers[i+1:])
+				watchers[len(watchers)-1] = nil
+				return watchers[:len(watchers)-1]
+			}
+		}
+		return watchers
+	}
+	if watcher.filter.streamIDs != nil {
+		for _, streamID := range watcher.filter.streamIDs {
+			watchers := remove(endWatchers.multiWatcherMap[streamID])
+			if len(watchers) == 0 {
+				delete(endWatchers.multiWatcherMap, streamID)
+			} else {
+				endWatchers.multiWatcherMap[streamID] = watchers
+			}
+		}
+	} else {
+		endWatchers.rangeWatchers = remove(endWatchers.rangeWatchers)
+	}
+}
+
+//notifyMultiWatchers pass the end of stream to the MultiWatchers of it
+func (endWatchers *endWatchers) notifyMultiWatchers(streamID int64, end int64) {
+	endWatchers.endWatcherLock.RLock()
+	defer endWatchers.endWatcherLock.RUnlock()
+	for _, watcher := range endWatchers.multiWatcherMap[streamID] {
+		watcher.update(streamID, end)
+	}
+	for _, watcher := range endWatchers.rangeWatchers {
+		if watcher.filter.match(streamID) {
+			watcher.update(streamID, end)
+		}
+	}
+}
+
 func (endWatchers *endWatchers) getEndWatcher(streamID int64) []endWatcher {
 	endWatchers.endWatcherLock.RLock()
 	watcher, _ := endWatchers.endWatcherMap[streamID]
@@ -103,12 +323,16 @@ func (endWatchers *endWatchers) start() {
 
 			for _, item := range items {
 				if item.end == closeSignal {
+					endWatchers.closeEndWaiters()
+					endWatchers.closeMultiWatchers()
 					close(endWatchers.s)
 					return
 				}
 				for _, watcher := range endWatchers.getEndWatcher(item.streamID) {
 					watcher.notify(item.end)
 				}
+				endWatchers.wakeEndWaiters(item.streamID, item.end)
+				endWatchers.notifyMultiWatchers(item.streamID, item.end)
 				notifyPool.Put(item)
 			}
 		}
@@ -128,6 +352,9 @@ func (endWatchers *endWatchers) notify(item *notify) {
 }
 
 func (watcher *endWatcher) notify(pos int64) {
+	if pos <= watcher.end {
+		return
+	}
 	select {
 	case watcher.int64s <- pos:
 	default:
@@ -35,6 +35,17 @@ type entry struct {
 	end      int64
 	err      error
 	cb       func(end int64, err error)
+	//batch is the entries of batch entry,it is decoded from data
+	batch []*entry
+	//producerID,seq identify the batch entry of producer
+	producerID int64
+	seq        int64
+	//epoch is the epoch of writer of the fenced batch entry
+	epoch int64
+	//ts is the unix nano time of append,0 for the entries written without it
+	ts int64
+	//mode is the append mode of the entries of record batch entry
+	mode byte
 }
 
 var entriesPool = sync.Pool{New: func() interface{} {
@@ -46,7 +57,16 @@ func (e *entry) size() int {
 		8 /*StreamID*/ +
 		8 + /*Offset*/
 		16 /*ver*/ +
-		4 + len(e.data)
+		4 + len(e.data) +
+		e.tsSize()
+}
+
+//tsSize return the size of ts,it follows data if the entry has it
+func (e *entry) tsSize() int {
+	if e.ts == 0 {
+		return 0
+	}
+	return 8
 }
 
 func (e *entry) encode() []byte {
@@ -81,6 +101,11 @@ func (e *entry) write(writer io.Writer) error {
 	if _, err := writer.Write(e.data); err != nil {
 		return errors.WithStack(err)
 	}
+	if e.ts != 0 {
+		if err := binary.Write(writer, binary.BigEndian, e.ts); err != nil {
+			return errors.WithStack(err)
+		}
+	}
 	return nil
 }
 
@@ -109,7 +134,7 @@ func decodeEntry(reader io.Reader) (*entry, error) {
 	if err := binary.Read(reader, binary.BigEndian, &dataLen); err != nil {
 		return nil, err
 	}
-	if size != dataLen+44 {
+	if size != dataLen+44 && size != dataLen+52 {
 		return nil, errors.WithStack(io.ErrUnexpectedEOF)
 	}
 	e.data = make([]byte, dataLen)
@@ -121,5 +146,11 @@ func decodeEntry(reader io.Reader) (*entry, error) {
 		return nil, errors.WithMessage(io.ErrUnexpectedEOF,
 			fmt.Sprintf("n[%d] datalen[%d]", n, dataLen))
 	}
+	//the entries written before timestamps have no ts
+	if size == dataLen+52 {
+		if err := binary.Read(reader, binary.BigEndian, &e.ts); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
 	return e, nil
 }
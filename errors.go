@@ -26,4 +26,18 @@ var (
 	ErrWhence            = errors.New("whence error")
 	ErrWal               = errors.New("journal error")
 	ErrClose             = errors.New("SStore close")
+	ErrReadOnly          = errors.New("SStore is read only")
+	ErrLocked            = errors.New("SStore directory is locked")
+	ErrLockUnsupported   = errors.New("file lock is unsupported on this platform")
+	ErrFollower          = errors.New("SStore is following a leader")
+	ErrEntryGC           = errors.New("entry has been deleted by GC")
+	ErrDuplicate         = errors.New("producer sequence is duplicate")
+	ErrSequence          = errors.New("producer sequence is out of order")
+	ErrFenced            = errors.New("epoch is fenced")
+	ErrRecord            = errors.New("record frame error")
+	ErrLease             = errors.New("record is not leased")
+	ErrStreamExist       = errors.New("stream is exist")
+	ErrInvalidStreamID   = errors.New("stream ID is reserved")
+	ErrStreamMode        = errors.New("append mode of stream mismatch")
+	ErrBroken            = errors.New("SStore is broken by failed restore")
 )
@@ -0,0 +1,137 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+//StreamSpan is the range of the data of stream in a segment
+type StreamSpan struct {
+	StreamID int64
+	Begin    int64
+	End      int64
+}
+
+//FlushEvent is a mStreamTable frozen and flushed to the segment
+type FlushEvent struct {
+	Filename    string
+	Size        int64
+	LastEntryID int64
+	//Duration is the time from the table frozen to the segment loaded
+	Duration time.Duration
+	Streams  []StreamSpan
+}
+
+//SegmentDeleteEvent is a segment deleted by GC
+type SegmentDeleteEvent struct {
+	Filename string
+	Size     int64
+	Streams  []StreamSpan
+}
+
+//WalRotateEvent is the journal full and the next one created
+type WalRotateEvent struct {
+	Filename    string
+	Size        int64
+	LastEntryID int64
+	NewFilename string
+}
+
+//WalDeleteEvent is a journal deleted after its entries flushed to the segments
+type WalDeleteEvent struct {
+	Filename    string
+	Size        int64
+	LastEntryID int64
+}
+
+//BeginMoveEvent is the begin of stream moved forward by GC,
+//Begin is equal to End if no data of stream is left
+type BeginMoveEvent struct {
+	StreamID int64
+	OldBegin int64
+	Begin    int64
+	End      int64
+}
+
+//RecoveryEvent is the store loaded from the segments and journals
+type RecoveryEvent struct {
+	Segments []string
+	Journals []string
+	//Entries is the count of entries replayed from the journals
+	Entries     int64
+	LastEntryID int64
+	Duration    time.Duration
+}
+
+//EventListener receive the lifecycle events of the store.
+//the methods are called in the goroutines of the store,they must not block
+//or call the store back,eg: GC calls OnSegmentDelete while holding its lock.
+//embed BaseEventListener to implement part of them
+type EventListener interface {
+	OnFlush(event FlushEvent)
+	OnSegmentDelete(event SegmentDeleteEvent)
+	OnWalRotate(event WalRotateEvent)
+	OnWalDelete(event WalDeleteEvent)
+	OnBeginMove(event BeginMoveEvent)
+	OnRecovery(event RecoveryEvent)
+}
+
+//BaseEventListener ignore all the events
+type BaseEventListener struct{}
+
+func (BaseEventListener) OnFlush(FlushEvent)                 {}
+func (BaseEventListener) OnSegmentDelete(SegmentDeleteEvent) {}
+func (BaseEventListener) OnWalRotate(WalRotateEvent)         {}
+func (BaseEventListener) OnWalDelete(WalDeleteEvent)         {}
+func (BaseEventListener) OnBeginMove(BeginMoveEvent)         {}
+func (BaseEventListener) OnRecovery(RecoveryEvent)           {}
+
+//eventListener return the listener of options,the events are ignored without it
+func (opt Options) eventListener() EventListener {
+	if opt.EventListener == nil {
+		return BaseEventListener{}
+	}
+	return opt.EventListener
+}
+
+//segmentStreams return the ranges of streams in the segment by stream ID
+func segmentStreams(segment *segment) []StreamSpan {
+	var streams = make([]StreamSpan, 0, len(segment.meta.OffSetInfos))
+	for _, info := range segment.meta.OffSetInfos {
+		if segment.isSuperseded(info.StreamID) {
+			continue
+		}
+		streams = append(streams, StreamSpan{
+			StreamID: info.StreamID,
+			Begin:    info.Begin,
+			End:      info.End,
+		})
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].StreamID < streams[j].StreamID
+	})
+	return streams
+}
+
+//fileSize return the size of file,0 if it fails
+func fileSize(filename string) int64 {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
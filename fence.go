@@ -0,0 +1,142 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"sync"
+)
+
+//epochTable is the fencing epochs of streams
+type epochTable struct {
+	l      sync.Mutex
+	epochs map[int64]int64
+}
+
+func newEpochTable() *epochTable {
+	return &epochTable{
+		epochs: make(map[int64]int64),
+	}
+}
+
+func (table *epochTable) get(streamID int64) int64 {
+	table.l.Lock()
+	defer table.l.Unlock()
+	return table.epochs[streamID]
+}
+
+func (table *epochTable) set(streamID int64, epoch int64) {
+	table.l.Lock()
+	defer table.l.Unlock()
+	table.epochs[streamID] = epoch
+}
+
+//clone return the copy of epochs to persist
+func (table *epochTable) clone() map[int64]int64 {
+	table.l.Lock()
+	defer table.l.Unlock()
+	var epochs = make(map[int64]int64, len(table.epochs))
+	for streamID, epoch := range table.epochs {
+		epochs[streamID] = epoch
+	}
+	return epochs
+}
+
+//load the epochs persisted
+func (table *epochTable) load(epochs map[int64]int64) {
+	table.l.Lock()
+	defer table.l.Unlock()
+	table.epochs = make(map[int64]int64, len(epochs))
+	for streamID, epoch := range epochs {
+		table.epochs[streamID] = epoch
+	}
+}
+
+//SetEpoch set the fencing epoch of the stream,the appends with older epoch
+//or without epoch are rejected with ErrFenced after it.
+//it returns ErrFenced if epoch is older than the epoch of stream
+func (sstore *SStore) SetEpoch(streamID int64, epoch int64) error {
+	if err := checkStreamID(streamID); err != nil {
+		return err
+	}
+	var buffer bytes.Buffer
+	_ = binary.Write(&buffer, binary.BigEndian, streamID)
+	_ = binary.Write(&buffer, binary.BigEndian, epoch)
+	var err error
+	sstore.syncCall(func(done func()) {
+		sstore.putEntry(&entry{
+			StreamID: epochStreamID,
+			Offset:   -1,
+			data:     buffer.Bytes(),
+			cb: func(_ int64, e error) {
+				err = e
+				done()
+			},
+		})
+	})
+	return err
+}
+
+//Epoch return the fencing epoch of the stream,0 if it is never set
+func (sstore *SStore) Epoch(streamID int64) int64 {
+	return sstore.committer.epochs.get(streamID)
+}
+
+//AppendWithEpoch append the data to end of the stream as the writer of epoch,
+//it returns ErrFenced if the epoch of stream is newer than epoch
+func (sstore *SStore) AppendWithEpoch(epoch int64, streamID int64, data []byte, offset int64) (AppendResult, error) {
+	results, err := sstore.AppendBatchWithEpoch(epoch, []AppendOp{{
+		StreamID: streamID,
+		Data:     data,
+		Offset:   offset,
+	}})
+	if err != nil {
+		return AppendResult{EntryID: -1, Begin: -1, End: -1}, err
+	}
+	return results[0], nil
+}
+
+//AppendBatchWithEpoch append the ops all-or-nothing as the writer of epoch
+func (sstore *SStore) AppendBatchWithEpoch(epoch int64, ops []AppendOp) ([]AppendResult, error) {
+	var err error
+	var results []AppendResult
+	sstore.syncCall(func(done func()) {
+		sstore.AsyncAppendBatchWithEpoch(epoch, ops, func(r []AppendResult, e error) {
+			results, err = r, e
+			done()
+		})
+	})
+	return results, err
+}
+
+//AsyncAppendBatchWithEpoch async append the ops all-or-nothing as the writer of epoch,
+//none of them is appended if the epoch of any stream is newer than epoch
+func (sstore *SStore) AsyncAppendBatchWithEpoch(epoch int64, ops []AppendOp,
+	cb func(results []AppendResult, err error)) {
+	sstore.asyncAppendBatchEntry(fencedStreamID, func(e *entry) {
+		e.epoch = epoch
+	}, ops, cb)
+}
+
+//decodeEpoch return the StreamID and epoch of the entry setting epoch
+func (e *entry) decodeEpoch() (int64, int64, error) {
+	if len(e.data) != 16 {
+		return 0, 0, errors.WithStack(io.ErrUnexpectedEOF)
+	}
+	return int64(binary.BigEndian.Uint64(e.data)),
+		int64(binary.BigEndian.Uint64(e.data[8:])), nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"sync/atomic"
+)
+
+//followReader read the stream and wait for the data appended at the end
+type followReader struct {
+	ctx      context.Context
+	sstore   *SStore
+	streamID int64
+	offset   int64
+	reader   *reader
+	watcher  Watcher
+	c        chan interface{}
+	isClose  int32
+}
+
+//FollowReader create the reader of the stream from the offset,
+//Read blocks at the end of stream until the data is appended,
+//the ctx is cancelled or the reader is closed.
+//the stream may be created after the reader
+func (sstore *SStore) FollowReader(ctx context.Context, streamID int64, from int64) (io.ReadCloser, error) {
+	//the watcher is created before reading,so no append is missed
+	reader := &followReader{
+		ctx:      ctx,
+		sstore:   sstore,
+		streamID: streamID,
+		offset:   from,
+		watcher:  sstore.Watcher(streamID),
+		c:        make(chan interface{}),
+	}
+	if err := reader.open(); err != nil {
+		reader.watcher.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+//open create the Reader of stream if it exists
+func (reader *followReader) open() error {
+	if reader.reader != nil {
+		return nil
+	}
+	r, err := reader.sstore.indexTable.reader(reader.streamID)
+	if err != nil {
+		if errors.Cause(err) == ErrNoFindStream {
+			return nil
+		}
+		return err
+	}
+	if _, err := r.Seek(reader.offset, io.SeekStart); err != nil {
+		return err
+	}
+	reader.reader = r
+	return nil
+}
+
+func (reader *followReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		if err := reader.open(); err != nil {
+			return 0, err
+		}
+		if reader.reader != nil {
+			n, err := reader.reader.Read(p)
+			//the gaps deleted by compaction are skipped
+			reader.offset = reader.reader.offset
+			if n > 0 || err != io.EOF {
+				return n, err
+			}
+		}
+		select {
+		case <-reader.c:
+			return 0, ErrClose
+		case <-reader.ctx.Done():
+			return 0, reader.ctx.Err()
+		case <-reader.watcher.Watch():
+		}
+	}
+}
+
+//Close the reader,the blocking Read returns ErrClose
+func (reader *followReader) Close() error {
+	if atomic.CompareAndSwapInt32(&reader.isClose, 0, 1) == false {
+		return errors.New("repeated close")
+	}
+	close(reader.c)
+	reader.watcher.Close()
+	return nil
+}
@@ -3,6 +3,7 @@ package sstore
 import (
 	"github.com/pkg/errors"
 	"log"
+	"path/filepath"
 	"sort"
 	"sync"
 )
@@ -18,6 +19,8 @@ type offsetIndex struct {
 	streamID int64
 	l        sync.RWMutex
 	items    []offsetItem
+	//compactedEnd is the end of the data compacted last time
+	compactedEnd int64
 }
 
 var offsetIndexNoFind = offsetItem{}
@@ -33,9 +36,17 @@ func newOffsetIndex(streamID int64, item offsetItem) *offsetIndex {
 func (index *offsetIndex) find(offset int64) (offsetItem, error) {
 	index.l.RLock()
 	defer index.l.RUnlock()
+	return index.search(offset)
+}
+
+func (index *offsetIndex) search(offset int64) (offsetItem, error) {
 	if len(index.items) == 0 {
 		return offsetIndexNoFind, errors.WithStack(ErrNoFindOffsetIndex)
 	}
+	if offset < index.items[0].begin {
+		return offsetIndexNoFind, errors.Wrapf(ErrEntryGC,
+			"stream[%d] offset[%d] begin[%d]", index.streamID, offset, index.items[0].begin)
+	}
 	if index.items[len(index.items)-1].begin <= offset {
 		return index.items[len(index.items)-1], nil
 	}
@@ -45,6 +56,39 @@ func (index *offsetIndex) find(offset int64) (offsetItem, error) {
 	return index.items[i-1], nil
 }
 
+//flushedItems return the items of the front of stream which are all flushed to segments,
+//the immutable mStreams of them may be not removed yet
+func (index *offsetIndex) flushedItems() []offsetItem {
+	index.l.RLock()
+	defer index.l.RUnlock()
+	var i int
+	for ; i < len(index.items) && index.items[i].segment != nil; i++ {
+	}
+	return append([]offsetItem(nil), index.items[:i]...)
+}
+
+//compact replace the items of the data before the end of compacted item with it,
+//the items replaced are returned.the item is not indexed if it is empty
+func (index *offsetIndex) compact(item offsetItem) ([]offsetItem, error) {
+	index.l.Lock()
+	defer index.l.Unlock()
+	var i int
+	for ; i < len(index.items) && index.items[i].end <= item.end; i++ {
+		if index.items[i].segment == nil {
+			return nil, errors.Errorf("compact stream[%d] end[%d] mStream begin[%d] not flushed",
+				index.streamID, item.end, index.items[i].begin)
+		}
+	}
+	var replaced = append([]offsetItem(nil), index.items[:i]...)
+	var items = make([]offsetItem, 0, len(index.items)-i+1)
+	if item.begin < item.end {
+		items = append(items, item)
+	}
+	index.items = append(items, index.items[i:]...)
+	index.compactedEnd = item.end
+	return replaced, nil
+}
+
 func (index *offsetIndex) update(item offsetItem) error {
 	index.l.Lock()
 	defer index.l.Unlock()
@@ -77,6 +121,41 @@ func (index *offsetIndex) update(item offsetItem) error {
 			item.begin, item.end)
 	}
 }
+
+//recordIndex return the sparse record index of the stream,
+//it is empty if the stream is not in record mode
+func (index *offsetIndex) recordIndex() []recordIndexItem {
+	index.l.RLock()
+	defer index.l.RUnlock()
+	var items []recordIndexItem
+	for _, item := range index.items {
+		if item.mStream != nil {
+			items = append(items, item.mStream.getRecordIndex()...)
+		} else if item.segment != nil {
+			items = append(items, item.segment.meta.OffSetInfos[index.streamID].RecordIndex...)
+		}
+	}
+	return items
+}
+
+//timeIndex return the sparse time index of the data of stream in order
+func (index *offsetIndex) timeIndex() []timeIndexChunk {
+	index.l.RLock()
+	defer index.l.RUnlock()
+	var chunks []timeIndexChunk
+	for _, item := range index.items {
+		var chunk timeIndexChunk
+		if item.mStream != nil {
+			chunk.items, chunk.lastTS = item.mStream.getTimeIndex()
+		} else if item.segment != nil {
+			info := item.segment.meta.OffSetInfos[index.streamID]
+			chunk.items, chunk.lastTS = info.TimeIndex, info.LastTS
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
 func (index *offsetIndex) begin() (int64, bool) {
 	index.l.RLock()
 	defer index.l.RUnlock()
@@ -86,6 +165,100 @@ func (index *offsetIndex) begin() (int64, bool) {
 	return index.items[0].begin, true
 }
 
+//front return true if the segment holds the first data of stream
+func (index *offsetIndex) front(segment *segment) bool {
+	index.l.RLock()
+	defer index.l.RUnlock()
+	return len(index.items) == 0 || index.items[0].segment == segment
+}
+
+//kept return the first offset of the data kept at or after offset in the item of it,
+//the data in the gaps deleted by compaction is skipped
+func (index *offsetIndex) kept(offset int64) int64 {
+	item, err := index.find(offset)
+	if err != nil || item.segment == nil {
+		return offset
+	}
+	offset, _ = item.segment.meta.OffSetInfos[index.streamID].kept(offset)
+	return offset
+}
+
+//stat set the begin of stream,the size of data in memory and on disk,
+//and the count of segments of it to info.the data flushed but not removed
+//from memory yet is counted in both
+func (index *offsetIndex) stat(info *StreamInfo) {
+	index.l.RLock()
+	defer index.l.RUnlock()
+	if len(index.items) > 0 {
+		info.Begin = index.items[0].begin
+	}
+	for _, item := range index.items {
+		if item.mStream != nil {
+			info.MemSize += item.mStream.size()
+		}
+		if item.segment != nil {
+			info.DiskSize += item.segment.meta.OffSetInfos[index.streamID].size()
+			info.Segments++
+		}
+	}
+}
+
+//extents return the ranges of the data kept in [begin,end),
+//it returns nil if there is no gap deleted by compaction
+func (index *offsetIndex) extents(begin int64, end int64) []offsetExtent {
+	index.l.RLock()
+	defer index.l.RUnlock()
+	var extents []offsetExtent
+	var gap bool
+	var add = func(b int64, e int64) {
+		if b < begin {
+			b = begin
+		}
+		if e > end {
+			e = end
+		}
+		if b >= e {
+			return
+		}
+		if n := len(extents); n > 0 && extents[n-1].End == b {
+			extents[n-1].End = e
+			return
+		}
+		var pos int64
+		if n := len(extents); n > 0 {
+			pos = extents[n-1].Pos + extents[n-1].End - extents[n-1].Begin
+		}
+		extents = append(extents, offsetExtent{Begin: b, End: e, Pos: pos})
+	}
+	for i, item := range index.items {
+		itemEnd := item.end
+		if i == len(index.items)-1 {
+			//the end of the last item is not updated by the appends
+			itemEnd = end
+		}
+		var info offsetInfo
+		if item.segment != nil {
+			info = item.segment.meta.OffSetInfos[index.streamID]
+		}
+		if len(info.Extents) == 0 {
+			add(item.begin, itemEnd)
+			continue
+		}
+		gap = true
+		for _, extent := range info.Extents {
+			add(extent.Begin, extent.End)
+		}
+	}
+	if gap == false {
+		return nil
+	}
+	if len(extents) == 0 {
+		//all the data is deleted,the empty extent makes [begin,end) a gap
+		extents = append(extents, offsetExtent{Begin: end, End: end})
+	}
+	return extents
+}
+
 func (index *offsetIndex) remove(item offsetItem) {
 	index.l.Lock()
 	defer index.l.Unlock()
@@ -107,6 +280,9 @@ func (index *offsetIndex) remove(item offsetItem) {
 			index.items[len(index.items)-1] = offsetItem{}
 			index.items = index.items[:len(index.items)-1]
 		}
+	} else if item.mStream != nil && item.end <= index.compactedEnd {
+		//the immutable mStream is replaced by compaction before it is removed
+		return
 	} else {
 		panic("index remove error")
 	}
@@ -118,9 +294,10 @@ type indexTable struct {
 	indexMap map[int64]*offsetIndex
 }
 
-func newIndexTable() *indexTable {
+func newIndexTable(endMap *int64LockMap) *indexTable {
 	return &indexTable{
 		l:        sync.RWMutex{},
+		endMap:   endMap,
 		indexMap: map[int64]*offsetIndex{},
 	}
 }
@@ -176,6 +353,10 @@ func (index *indexTable) update1(segment *segment) error {
 
 func (index *indexTable) remove1(segment *segment) error {
 	for _, info := range segment.meta.OffSetInfos {
+		//the data of stream is not indexed
+		if segment.isSuperseded(info.StreamID) || info.Begin == info.End {
+			continue
+		}
 		if offsetIndex := index.get(info.StreamID); offsetIndex != nil {
 			offsetIndex.remove(offsetItem{
 				segment: segment,
@@ -194,6 +375,48 @@ func (index *indexTable) remove1(segment *segment) error {
 	return nil
 }
 
+//compact index the compacted segment instead of the segments of stream it replaces,
+//it returns the filenames of compacted segments replaced,no data of them is indexed
+func (index *indexTable) compact(segment *segment) ([]string, error) {
+	var replaced []string
+	for _, info := range segment.meta.OffSetInfos {
+		item := offsetItem{
+			segment: segment,
+			mStream: nil,
+			begin:   info.Begin,
+			end:     info.End,
+		}
+		var offsetIndex *offsetIndex
+		if info.Begin == info.End {
+			//all the data of stream is compacted away
+			if offsetIndex = index.get(info.StreamID); offsetIndex == nil {
+				continue
+			}
+		} else {
+			segment.refInc()
+			var load bool
+			if offsetIndex, load = index.loadOrCreate(info.StreamID, item); load == false {
+				continue
+			}
+		}
+		items, err := offsetIndex.compact(item)
+		if err != nil {
+			return nil, err
+		}
+		for _, it := range items {
+			it.segment.supersede(info.StreamID)
+			it.segment.refDec()
+			if it.segment.meta.Compacted {
+				replaced = append(replaced, filepath.Base(it.segment.filename))
+			}
+		}
+		if _, ok := offsetIndex.begin(); ok == false {
+			index.removeEmptyOffsetIndex(info.StreamID)
+		}
+	}
+	return replaced, nil
+}
+
 func (index *indexTable) update(stream *mStream) {
 	item := offsetItem{
 		segment: nil,
@@ -232,3 +455,10 @@ func (index *indexTable) reader(streamID int64) (*reader, error) {
 	}
 	return newReader(streamID, offsetIndex, index.endMap), nil
 }
+
+//reset remove the index of all the streams
+func (index *indexTable) reset() {
+	index.l.Lock()
+	defer index.l.Unlock()
+	index.indexMap = map[int64]*offsetIndex{}
+}
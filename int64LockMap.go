@@ -87,9 +87,10 @@ func (sizeMap *int64LockMap) CloneMap() (map[int64]int64, Version) {
 		go func() {
 			defer sizeMap.cloneLocker.Unlock()
 			for {
-				if !sizeMap.mergeMap(20000) {
-					time.Sleep(time.Millisecond * 10)
+				if sizeMap.mergeMap(20000) {
+					return
 				}
+				time.Sleep(time.Millisecond * 10)
 			}
 		}()
 	}()
@@ -98,3 +99,14 @@ func (sizeMap *int64LockMap) CloneMap() (map[int64]int64, Version) {
 	}
 	return cloneMap, ver
 }
+
+//reset remove all the streams
+func (sizeMap *int64LockMap) reset() {
+	sizeMap.cloneLocker.Lock()
+	defer sizeMap.cloneLocker.Unlock()
+	sizeMap.locker.Lock()
+	defer sizeMap.locker.Unlock()
+	sizeMap.version = Version{}
+	sizeMap.level0 = nil
+	sizeMap.level1 = make(map[int64]int64, 1024)
+}
@@ -0,0 +1,75 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const lockFilename = "LOCK"
+
+//dirLock is the exclusive lock of the store directory,
+//the pid of the holder is written into the lock file
+type dirLock struct {
+	f *os.File
+}
+
+func lockDir(dir string) (*dirLock, error) {
+	filename := filepath.Join(dir, lockFilename)
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	locked, err := flock(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if locked == false {
+		data, _ := ioutil.ReadAll(f)
+		_ = f.Close()
+		pid := strings.TrimSpace(string(data))
+		if pid == "" {
+			pid = "unknown"
+		}
+		return nil, errors.WithMessage(ErrLocked,
+			fmt.Sprintf("[%s] is locked by pid %s", dir, pid))
+	}
+	if err := f.Truncate(0); err != nil {
+		_ = f.Close()
+		return nil, errors.WithStack(err)
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		_ = f.Close()
+		return nil, errors.WithStack(err)
+	}
+	return &dirLock{f: f}, nil
+}
+
+func (l *dirLock) unlock() error {
+	if err := funlock(l.f); err != nil {
+		_ = l.f.Close()
+		return err
+	}
+	if err := l.f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
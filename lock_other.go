@@ -0,0 +1,32 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly,!windows
+
+package sstore
+
+import (
+	"github.com/pkg/errors"
+	"os"
+)
+
+//flock is not supported on this platform,the directory can't be protected
+//from the other owners,so the store can only be opened read only
+func flock(f *os.File) (bool, error) {
+	return false, errors.WithStack(ErrLockUnsupported)
+}
+
+func funlock(f *os.File) error {
+	return nil
+}
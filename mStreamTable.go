@@ -14,6 +14,7 @@
 package sstore
 
 import (
+	"log"
 	"sync"
 	"time"
 )
@@ -23,19 +24,26 @@ type mStreamTable struct {
 	mSize       int64
 	lastEntryID int64
 	endMap      *int64LockMap
+	recordMap   *int64LockMap
 	GcTS        time.Time
 	mStreams    map[int64]*mStream
 	indexTable  *indexTable
 	blockSize   int
+	//producers are the producers state when the table is frozen
+	producers map[int64]producerState
+	epochs    map[int64]int64
+	modes     map[int64]byte
+	consumers map[string]map[int64]int64
 }
 
-func newMStreamTable(sizeMap *int64LockMap,
+func newMStreamTable(sizeMap *int64LockMap, recordMap *int64LockMap,
 	blockSize int, mStreamMapSize int) *mStreamTable {
 	return &mStreamTable{
 		blockSize:   blockSize,
 		mSize:       0,
 		lastEntryID: 0,
 		endMap:      sizeMap,
+		recordMap:   recordMap,
 		locker:      sync.Mutex{},
 		mStreams:    make(map[int64]*mStream, mStreamMapSize),
 	}
@@ -50,6 +58,7 @@ func (m *mStreamTable) loadOrCreateMStream(streamID int64) (*mStream, bool) {
 	}
 	size, _ := m.endMap.get(streamID)
 	ms = newMStream(size, m.blockSize, streamID)
+	ms.records, _ = m.recordMap.get(streamID)
 	m.mStreams[streamID] = ms
 	m.locker.Unlock()
 	return ms, false
@@ -60,9 +69,21 @@ func (m *mStreamTable) appendEntry(e *entry) (*mStream, int64) {
 	ms, load := m.loadOrCreateMStream(e.StreamID)
 	end := ms.write(e.Offset, e.data)
 	if end == -1 {
+		//the mStream is not indexed,the next entry creates it again
+		if load == false {
+			m.locker.Lock()
+			delete(m.mStreams, e.StreamID)
+			m.locker.Unlock()
+		}
 		return nil, -1
 	}
 	m.endMap.set(e.StreamID, end, e.ver)
+	if e.ts != 0 {
+		ms.appendTime(e.ts, end-int64(len(e.data)))
+	}
+	if e.mode != streamModeRaw {
+		m.recordMap.set(e.StreamID, ms.appendRecord(end-int64(len(e.data))), e.ver)
+	}
 	m.mSize += int64(len(e.data))
 	m.lastEntryID = e.ID
 	if load {
@@ -70,3 +91,42 @@ func (m *mStreamTable) appendEntry(e *entry) (*mStream, int64) {
 	}
 	return ms, end
 }
+
+//appendBatch append the entries of batch,it checks the offsets of all
+//the entries before append any of them.
+//it returns the mStreams created and false if any offset is wrong
+func (m *mStreamTable) appendBatch(offset int64, batch []*entry) ([]*mStream, bool) {
+	if isOffsetMarked(offset) {
+		return nil, false
+	}
+	var ends = make(map[int64]int64, len(batch))
+	for _, e := range batch {
+		end, ok := ends[e.StreamID]
+		if ok == false {
+			m.locker.Lock()
+			ms, ok := m.mStreams[e.StreamID]
+			m.locker.Unlock()
+			if ok {
+				end = ms.end
+			} else {
+				end, _ = m.endMap.get(e.StreamID)
+			}
+		}
+		if e.Offset != -1 && e.Offset != end {
+			return nil, false
+		}
+		ends[e.StreamID] = end + int64(len(e.data))
+	}
+	var mStreams []*mStream
+	for _, e := range batch {
+		ms, end := m.appendEntry(e)
+		if end == -1 {
+			log.Panicf("append batch entry stream[%d] offset[%d] failed", e.StreamID, e.Offset)
+		}
+		e.end = end
+		if ms != nil {
+			mStreams = append(mStreams, ms)
+		}
+	}
+	return mStreams, true
+}
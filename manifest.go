@@ -56,11 +56,15 @@ type manifest struct {
 	walIndex       int64
 	filesIndex     int64
 	inRecovery     bool
+	readOnly       bool
 
 	EntryID      int64                  `json:"entry_id"`
 	Segments     []string               `json:"segments"`
 	Journals     []string               `json:"journals"`
 	WalHeaderMap map[string]JournalMeta `json:"wal_header_map"`
+	//Catalog are the streams by name,LastStreamID is the last ID allocated
+	Catalog      map[string]StreamMeta `json:"catalog,omitempty"`
+	LastStreamID int64                 `json:"last_stream_id,omitempty"`
 
 	notifySnap chan interface{}
 	c          chan interface{}
@@ -75,6 +79,9 @@ const (
 	setWalHeaderType            //= "setWalHeader" //set journal meta
 	delWalHeaderType            //= "delWalHeader" //set journal meta
 	manifestSnapshotType        //= "filesSnapshot"
+	createStreamType            //= "createStream" //add stream to catalog
+	setStreamAttrsType          //= "setStreamAttrs"
+	dropStreamType              //= "dropStream"
 
 	segmentExt            = ".seg"
 	manifestExt           = ".log"
@@ -82,7 +89,7 @@ const (
 	manifestJournalExtTmp = ".mlog.tmp"
 )
 
-func openManifest(manifestDir string, segmentDir string, walDir string) (*manifest, error) {
+func openManifest(manifestDir string, segmentDir string, walDir string, readOnly bool) (*manifest, error) {
 	files := &manifest{
 		maxJournalSize: 128 * MB,
 		journal:        nil,
@@ -94,6 +101,7 @@ func openManifest(manifestDir string, segmentDir string, walDir string) (*manife
 		walIndex:       0,
 		filesIndex:     0,
 		inRecovery:     false,
+		readOnly:       readOnly,
 		EntryID:        0,
 		Segments:       make([]string, 0, 128),
 		Journals:       make([]string, 0, 128),
@@ -101,6 +109,7 @@ func openManifest(manifestDir string, segmentDir string, walDir string) (*manife
 		c:              make(chan interface{}, 1),
 		s:              make(chan interface{}, 1),
 		WalHeaderMap:   make(map[string]JournalMeta),
+		Catalog:        make(map[string]StreamMeta),
 	}
 	if err := files.reload(); err != nil {
 		return nil, err
@@ -134,7 +143,7 @@ func (f *manifest) reload() error {
 		if err != nil {
 			return errors.WithStack(err)
 		}
-		if strings.HasSuffix(info.Name(), manifestJournalExtTmp) {
+		if strings.HasSuffix(info.Name(), manifestJournalExtTmp) && f.readOnly == false {
 			_ = os.Remove(path)
 		}
 		if strings.HasSuffix(info.Name(), manifestJournalExt) {
@@ -147,7 +156,17 @@ func (f *manifest) reload() error {
 	}
 
 	sortIntFilename(logFiles)
-	if len(logFiles) == 0 {
+	if f.readOnly {
+		if len(logFiles) == 0 {
+			return errors.Errorf("no find manifest in [%s]", f.manifestDir)
+		}
+		f.journal, err = openJournalReadOnly(logFiles[len(logFiles)-1])
+		if err != nil {
+			return err
+		}
+		//the older manifest journals belong to the owner of the store
+		logFiles = logFiles[len(logFiles)-1:]
+	} else if len(logFiles) == 0 {
 		f.filesIndex = 1
 		f.journal, err = openJournal(filepath.Join(f.manifestDir, "1"+manifestJournalExt))
 	} else {
@@ -156,7 +175,7 @@ func (f *manifest) reload() error {
 			return err
 		}
 	}
-	err = f.journal.Read(func(e *entry) error {
+	var apply = func(e *entry) error {
 		f.EntryID = e.ID
 		switch e.StreamID {
 		case appendSegmentType:
@@ -193,11 +212,42 @@ func (f *manifest) reload() error {
 				return errors.WithStack(err)
 			}
 			return f.setWalHeader(header)
+		case delWalHeaderType:
+			var header delWalHeader
+			if err := json.Unmarshal(e.data, &header); err != nil {
+				return errors.WithStack(err)
+			}
+			return f.delWalHeader(header)
+		case createStreamType:
+			var meta StreamMeta
+			if err := json.Unmarshal(e.data, &meta); err != nil {
+				return errors.WithStack(err)
+			}
+			_, err := f.createStream(meta)
+			return err
+		case setStreamAttrsType:
+			var meta StreamMeta
+			if err := json.Unmarshal(e.data, &meta); err != nil {
+				return errors.WithStack(err)
+			}
+			return f.setStreamAttrs(meta)
+		case dropStreamType:
+			var drop dropStream
+			if err := json.Unmarshal(e.data, &drop); err != nil {
+				return errors.WithStack(err)
+			}
+			return f.dropStream(drop)
 		default:
 			log.Fatalf("unknown type %d", e.StreamID)
 		}
 		return nil
-	})
+	}
+	if f.readOnly {
+		//the owner may be appending to the manifest journal right now
+		_, err = f.journal.ReadFrom(0, apply)
+	} else {
+		err = f.journal.Read(apply)
+	}
 	if err != nil {
 		return err
 	}
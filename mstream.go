@@ -28,6 +28,14 @@ type mStream struct {
 	end       int64
 	bufPages  []bufPage
 	blockSize int
+	//records is the count of records of stream before end,
+	//recordIndex is the sparse index of the records in mStream
+	records     int64
+	recordIndex []recordIndexItem
+	//timeIndex is the sparse index of append time,
+	//lastTS is the time of the last append
+	timeIndex []timeIndexItem
+	lastTS    int64
 }
 
 const mStreamEnd = math.MaxInt64
@@ -96,6 +104,104 @@ func (m *mStream) write(offset int64, p []byte) int64 {
 	return m.end
 }
 
+//appendRecord index the record at offset,it returns the count of records
+func (m *mStream) appendRecord(offset int64) int64 {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if len(m.recordIndex) == 0 || m.records%recordIndexInterval == 0 {
+		m.recordIndex = append(m.recordIndex, recordIndexItem{
+			Seq:    m.records,
+			Offset: offset,
+		})
+	}
+	m.records++
+	return m.records
+}
+
+//appendTime index the entry appended at ts to the offset
+func (m *mStream) appendTime(ts int64, offset int64) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if len(m.timeIndex) == 0 ||
+		ts >= m.timeIndex[len(m.timeIndex)-1].TS+int64(timeIndexInterval) {
+		m.timeIndex = append(m.timeIndex, timeIndexItem{
+			TS:     ts,
+			Offset: offset,
+		})
+	}
+	if ts > m.lastTS {
+		m.lastTS = ts
+	}
+}
+
+//getTimeIndex return the copy of sparse time index and the time of last append
+func (m *mStream) getTimeIndex() ([]timeIndexItem, int64) {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	return append([]timeIndexItem(nil), m.timeIndex...), m.lastTS
+}
+
+//getRecordIndex return the copy of sparse record index
+func (m *mStream) getRecordIndex() []recordIndexItem {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	return append([]recordIndexItem(nil), m.recordIndex...)
+}
+
+//size return the size of data in mStream
+func (m *mStream) size() int64 {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	return m.end - m.begin
+}
+
+//cut return a new mStream with the data from the offset to the end,
+//records is the count of records before the offset
+func (m *mStream) cut(offset int64, records int64) *mStream {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	ms := newMStream(offset, m.blockSize, m.streamID)
+	ms.records = m.records
+	for _, item := range m.recordIndex {
+		if item.Offset >= offset {
+			ms.recordIndex = append(ms.recordIndex, item)
+		}
+	}
+	//the first record of mStream is always indexed
+	if m.records > records && (len(ms.recordIndex) == 0 || ms.recordIndex[0].Offset != offset) {
+		ms.recordIndex = append([]recordIndexItem{{Seq: records, Offset: offset}}, ms.recordIndex...)
+	}
+	ms.lastTS = m.lastTS
+	for i, item := range m.timeIndex {
+		if item.Offset < offset {
+			continue
+		}
+		//the entry at offset is after the item before it
+		if item.Offset > offset && i > 0 {
+			ms.timeIndex = append(ms.timeIndex, timeIndexItem{
+				TS:     m.timeIndex[i-1].TS,
+				Offset: offset,
+			})
+		}
+		ms.timeIndex = append(ms.timeIndex, m.timeIndex[i:]...)
+		break
+	}
+	if len(ms.timeIndex) == 0 && len(m.timeIndex) > 0 && m.end > offset {
+		ms.timeIndex = append(ms.timeIndex, timeIndexItem{
+			TS:     m.timeIndex[len(m.timeIndex)-1].TS,
+			Offset: offset,
+		})
+	}
+	index := (offset - m.begin) / int64(m.blockSize)
+	pos := (offset - m.begin) % int64(m.blockSize)
+	for ; index < int64(len(m.bufPages)); index++ {
+		block := &m.bufPages[index]
+		ms.write(-1, block.buf[pos:block.limit])
+		pos = 0
+	}
+	return ms
+}
+
 func (m *mStream) writeTo(writer io.Writer) (int, error) {
 	m.locker.RLock()
 	defer m.locker.RUnlock()
@@ -0,0 +1,143 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"sync"
+)
+
+//StreamEnd is the end of stream after appends
+type StreamEnd struct {
+	StreamID int64
+	End      int64
+}
+
+//StreamFilter select the streams of MultiWatcher
+type StreamFilter struct {
+	streamIDs []int64
+	//begin,end is the range of stream IDs when streamIDs is nil
+	begin int64
+	end   int64
+	all   bool
+}
+
+//StreamSet select the streams of the IDs
+func StreamSet(streamIDs ...int64) StreamFilter {
+	return StreamFilter{streamIDs: append([]int64{}, streamIDs...)}
+}
+
+//StreamRange select the streams of the IDs in [begin,end)
+func StreamRange(begin int64, end int64) StreamFilter {
+	return StreamFilter{begin: begin, end: end}
+}
+
+//AllStreams select all the streams
+func AllStreams() StreamFilter {
+	return StreamFilter{all: true}
+}
+
+func (filter StreamFilter) match(streamID int64) bool {
+	return filter.all || (filter.begin <= streamID && streamID < filter.end)
+}
+
+//MultiWatcher watch the ends of the streams selected by filter,
+//the updates of a stream are coalesced to the last end until they are delivered,
+//so it keeps at most one pending update for each stream
+type MultiWatcher struct {
+	endWatchers *endWatchers
+	filter      StreamFilter
+	l           sync.Mutex
+	pending     map[int64]int64
+	signal      chan interface{}
+	c           chan StreamEnd
+	cb          func(StreamEnd)
+	closeOnce   sync.Once
+	s           chan interface{}
+	done        chan interface{}
+}
+
+//MultiWatcher create the watcher delivering the updates on the chan of Watch,
+//the watchers still open are closed by Close of store
+func (sstore *SStore) MultiWatcher(filter StreamFilter) *MultiWatcher {
+	return sstore.endWatchers.newMultiWatcher(filter, nil)
+}
+
+//WatchFunc create the watcher delivering the updates to cb,
+//cb is called in the goroutine of watcher one update at a time
+func (sstore *SStore) WatchFunc(filter StreamFilter, cb func(StreamEnd)) *MultiWatcher {
+	return sstore.endWatchers.newMultiWatcher(filter, cb)
+}
+
+//Watch return the chan of updates,it is closed after the watcher closed
+func (watcher *MultiWatcher) Watch() <-chan StreamEnd {
+	return watcher.c
+}
+
+//Close stop the watcher,no update is delivered after it returns.
+//it must not be called in the cb of WatchFunc
+func (watcher *MultiWatcher) Close() {
+	watcher.closeOnce.Do(func() {
+		watcher.endWatchers.removeMultiWatcher(watcher)
+		close(watcher.s)
+		<-watcher.done
+	})
+}
+
+//update coalesce the end of stream to the pending updates
+func (watcher *MultiWatcher) update(streamID int64, end int64) {
+	watcher.l.Lock()
+	if last, ok := watcher.pending[streamID]; ok == false || last < end {
+		watcher.pending[streamID] = end
+	}
+	watcher.l.Unlock()
+	select {
+	case watcher.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (watcher *MultiWatcher) start() {
+	go func() {
+		defer close(watcher.done)
+		if watcher.cb == nil {
+			defer close(watcher.c)
+		}
+		var buf = make(map[int64]int64)
+		for {
+			select {
+			case <-watcher.s:
+				return
+			case <-watcher.signal:
+			}
+			watcher.l.Lock()
+			updates := watcher.pending
+			watcher.pending = buf
+			watcher.l.Unlock()
+			for streamID, end := range updates {
+				update := StreamEnd{StreamID: streamID, End: end}
+				if watcher.cb != nil {
+					watcher.cb(update)
+				} else {
+					select {
+					case watcher.c <- update:
+					case <-watcher.s:
+						return
+					}
+				}
+				delete(updates, streamID)
+			}
+			buf = updates
+		}
+	}()
+}
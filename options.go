@@ -16,6 +16,7 @@ package sstore
 import (
 	"math"
 	"path/filepath"
+	"time"
 )
 
 type Options struct {
@@ -29,6 +30,23 @@ type Options struct {
 	MaxImmutableMStreamTableCount int    `json:"max_immutable_mStream_table_count"`
 	EntryQueueCap                 int    `json:"entry_queue_cap"`
 	MaxWalSize                    int64  `json:"max_wal_size"`
+
+	//ReadOnly open the store without writing anything to the directory,
+	//so it can run next to the process which owns it.
+	//the owner takes the exclusive lock of the directory,Open returns
+	//ErrLockUnsupported on the platforms without file locks unless ReadOnly
+	ReadOnly bool `json:"read_only"`
+	//RefreshInterval is the interval of a read only store to pick up
+	//the journal data written by the owner,0 means never refresh
+	RefreshInterval time.Duration `json:"refresh_interval"`
+	//Clock is the clock of append timestamps,nil means time.Now
+	Clock func() time.Time `json:"-"`
+	//EventListener receive the lifecycle events of the store
+	EventListener EventListener `json:"-"`
+	//ConsumerRetention keep the segments holding the data which
+	//a registered consumer has not read past,the later segments are kept
+	//too if they hold the data of the streams in the segments kept
+	ConsumerRetention bool `json:"consumer_retention"`
 }
 
 const MB = 1024 * 1024
@@ -102,3 +120,33 @@ func (opt Options) WithEntryQueueCap(val int) Options {
 	opt.EntryQueueCap = val
 	return opt
 }
+
+//WithReadOnly
+func (opt Options) WithReadOnly(val bool) Options {
+	opt.ReadOnly = val
+	return opt
+}
+
+//WithRefreshInterval
+func (opt Options) WithRefreshInterval(val time.Duration) Options {
+	opt.RefreshInterval = val
+	return opt
+}
+
+//WithClock
+func (opt Options) WithClock(val func() time.Time) Options {
+	opt.Clock = val
+	return opt
+}
+
+//WithEventListener
+func (opt Options) WithEventListener(val EventListener) Options {
+	opt.EventListener = val
+	return opt
+}
+
+//WithConsumerRetention
+func (opt Options) WithConsumerRetention(val bool) Options {
+	opt.ConsumerRetention = val
+	return opt
+}
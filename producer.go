@@ -0,0 +1,161 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"sync"
+)
+
+//producerWindow is the count of results of recent appends a producer keeps,
+//the retried appends in it are acknowledged with the original results
+const producerWindow = 8
+
+type producerResult struct {
+	Seq     int64          `json:"seq"`
+	Results []AppendResult `json:"results"`
+}
+
+type producerState struct {
+	Seq     int64            `json:"seq"`
+	Results []producerResult `json:"results"`
+}
+
+//producerTable is the sequences and recent results of producers
+type producerTable struct {
+	l         sync.Mutex
+	producers map[int64]*producerState
+}
+
+func newProducerTable() *producerTable {
+	return &producerTable{
+		producers: make(map[int64]*producerState),
+	}
+}
+
+//seq return the sequence of last append of the producer
+func (table *producerTable) seq(producerID int64) (int64, bool) {
+	table.l.Lock()
+	defer table.l.Unlock()
+	state, ok := table.producers[producerID]
+	if ok == false {
+		return 0, false
+	}
+	return state.Seq, true
+}
+
+//results return the results of the append of seq,nil if it is out of window
+func (table *producerTable) results(producerID int64, seq int64) []AppendResult {
+	table.l.Lock()
+	defer table.l.Unlock()
+	state, ok := table.producers[producerID]
+	if ok == false {
+		return nil
+	}
+	for _, result := range state.Results {
+		if result.Seq == seq {
+			return result.Results
+		}
+	}
+	return nil
+}
+
+//commit record the results of the append of seq
+func (table *producerTable) commit(producerID int64, seq int64, results []AppendResult) {
+	table.l.Lock()
+	defer table.l.Unlock()
+	state, ok := table.producers[producerID]
+	if ok == false {
+		state = new(producerState)
+		table.producers[producerID] = state
+	}
+	state.Seq = seq
+	state.Results = append(state.Results, producerResult{Seq: seq, Results: results})
+	if len(state.Results) > producerWindow {
+		copy(state.Results, state.Results[1:])
+		state.Results[len(state.Results)-1] = producerResult{}
+		state.Results = state.Results[:len(state.Results)-1]
+	}
+}
+
+//clone return the copy of producers to persist
+func (table *producerTable) clone() map[int64]producerState {
+	table.l.Lock()
+	defer table.l.Unlock()
+	var producers = make(map[int64]producerState, len(table.producers))
+	for producerID, state := range table.producers {
+		producers[producerID] = producerState{
+			Seq:     state.Seq,
+			Results: append([]producerResult(nil), state.Results...),
+		}
+	}
+	return producers
+}
+
+//load the producers persisted
+func (table *producerTable) load(producers map[int64]producerState) {
+	table.l.Lock()
+	defer table.l.Unlock()
+	table.producers = make(map[int64]*producerState, len(producers))
+	for producerID, state := range producers {
+		state := state
+		table.producers[producerID] = &state
+	}
+}
+
+//AppendIdempotent append the data to end of the stream as the append seq
+//of the producer.the seq of producer starts from 1 and increases by 1,
+//the append retried is acknowledged with the original result
+//instead of being written again
+func (sstore *SStore) AppendIdempotent(producerID int64, seq int64,
+	streamID int64, data []byte, offset int64) (AppendResult, error) {
+	results, err := sstore.AppendBatchIdempotent(producerID, seq, []AppendOp{{
+		StreamID: streamID,
+		Data:     data,
+		Offset:   offset,
+	}})
+	if err != nil {
+		return AppendResult{EntryID: -1, Begin: -1, End: -1}, err
+	}
+	return results[0], nil
+}
+
+//AppendBatchIdempotent append the ops all-or-nothing as the append seq of the producer
+func (sstore *SStore) AppendBatchIdempotent(producerID int64, seq int64, ops []AppendOp) ([]AppendResult, error) {
+	var err error
+	var results []AppendResult
+	sstore.syncCall(func(done func()) {
+		sstore.AsyncAppendBatchIdempotent(producerID, seq, ops, func(r []AppendResult, e error) {
+			results, err = r, e
+			done()
+		})
+	})
+	return results, err
+}
+
+//AsyncAppendBatchIdempotent async append the ops all-or-nothing as the append seq
+//of the producer.cb gets ErrSequence if seq is not the next one,
+//and ErrDuplicate if seq is retried and its result is out of window
+func (sstore *SStore) AsyncAppendBatchIdempotent(producerID int64, seq int64, ops []AppendOp,
+	cb func(results []AppendResult, err error)) {
+	sstore.asyncAppendBatchEntry(producerStreamID, func(e *entry) {
+		e.producerID = producerID
+		e.seq = seq
+	}, ops, cb)
+}
+
+//ProducerSeq return the sequence of the last append of the producer,
+//the producer continues from it after restart
+func (sstore *SStore) ProducerSeq(producerID int64) (int64, bool) {
+	return sstore.committer.producers.seq(producerID)
+}
@@ -0,0 +1,350 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+//the operations of the state stream of queue,
+//the record of them is op,deadline and the offsets of records
+const (
+	//queueOpLease lease the records of the begin,end pairs until deadline
+	queueOpLease = 1
+	//queueOpAck ack the records of the begins
+	queueOpAck = 2
+	//queueOpCheckpoint replace the state,the deadline of it is the next offset
+	//and the offsets are the begin,end,deadline of the leases
+	queueOpCheckpoint = 3
+)
+
+//queueCheckpointInterval is the count of state records between checkpoints
+const queueCheckpointInterval = 128
+
+//Lease is a record leased from the queue,
+//it is redelivered after Deadline if it is not acked
+type Lease struct {
+	Record
+	Deadline time.Time
+}
+
+//queueLease is the lease of a record not acked
+type queueLease struct {
+	end      int64
+	deadline int64
+}
+
+//Queue is the durable work queue of the record-framed stream,
+//the leases and acks are appended to the state stream as records,
+//they are replayed from the last checkpoint when the queue is opened again.
+//the checkpoint is pinned against GC,the state records before it may be deleted
+type Queue struct {
+	sstore        *SStore
+	streamID      int64
+	stateStreamID int64
+	visibility    time.Duration
+	clock         func() time.Time
+	l             sync.Mutex
+	reader        *RecordReader
+	//next is the offset of the first record never leased
+	next int64
+	//leases are the leases of records not acked by the begins of them
+	leases map[int64]queueLease
+	//ops is the count of state records since the last checkpoint
+	ops int
+}
+
+//OpenQueue open the queue of the records of streamID,the state of it is kept in
+//stateStreamID.the records leased are redelivered after visibility if they are not acked.
+//the time of leases is Options.Clock
+func (sstore *SStore) OpenQueue(streamID int64, stateStreamID int64, visibility time.Duration) (*Queue, error) {
+	if streamID == stateStreamID {
+		return nil, errors.Errorf("queue stream[%d] is the state stream", streamID)
+	}
+	clock := sstore.options.Clock
+	if clock == nil {
+		clock = time.Now
+	}
+	queue := &Queue{
+		sstore:        sstore,
+		streamID:      streamID,
+		stateStreamID: stateStreamID,
+		visibility:    visibility,
+		clock:         clock,
+		leases:        make(map[int64]queueLease),
+	}
+	if err := queue.replay(); err != nil {
+		return nil, err
+	}
+	//the state stream written before checkpoints has no pin
+	if _, ok := sstore.GetOffset(queueConsumer, stateStreamID); ok == false {
+		if err := queue.checkpoint(); err != nil {
+			return nil, err
+		}
+	}
+	return queue, nil
+}
+
+//replay the state stream from the last checkpoint
+func (queue *Queue) replay() error {
+	reader, err := queue.sstore.RecordReader(queue.stateStreamID)
+	if err != nil {
+		if errors.Cause(err) == ErrNoFindStream {
+			return nil
+		}
+		return err
+	}
+	if offset, ok := queue.sstore.GetOffset(queueConsumer, queue.stateStreamID); ok {
+		if err := reader.seek(offset); err != nil {
+			return err
+		}
+	}
+	for {
+		record, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := queue.apply(record.Data); err != nil {
+			return errors.WithMessage(err, fmt.Sprintf("state stream[%d] offset[%d]",
+				queue.stateStreamID, record.Begin))
+		}
+	}
+}
+
+//apply the operation of the state record
+func (queue *Queue) apply(data []byte) error {
+	if len(data) < 9 || (len(data)-9)%8 != 0 {
+		return errors.WithStack(ErrRecord)
+	}
+	op := data[0]
+	deadline := int64(binary.BigEndian.Uint64(data[1:]))
+	var offsets = make([]int64, 0, (len(data)-9)/8)
+	for i := 9; i < len(data); i += 8 {
+		offsets = append(offsets, int64(binary.BigEndian.Uint64(data[i:])))
+	}
+	switch op {
+	case queueOpLease:
+		if len(offsets)%2 != 0 {
+			return errors.WithStack(ErrRecord)
+		}
+		for i := 0; i < len(offsets); i += 2 {
+			queue.leases[offsets[i]] = queueLease{end: offsets[i+1], deadline: deadline}
+			if offsets[i+1] > queue.next {
+				queue.next = offsets[i+1]
+			}
+		}
+		queue.ops++
+	case queueOpAck:
+		for _, begin := range offsets {
+			delete(queue.leases, begin)
+		}
+		queue.ops++
+	case queueOpCheckpoint:
+		if len(offsets)%3 != 0 {
+			return errors.WithStack(ErrRecord)
+		}
+		queue.next = deadline
+		queue.leases = make(map[int64]queueLease, len(offsets)/3)
+		for i := 0; i < len(offsets); i += 3 {
+			queue.leases[offsets[i]] = queueLease{end: offsets[i+1], deadline: offsets[i+2]}
+		}
+		queue.ops = 0
+	default:
+		return errors.WithMessage(ErrRecord, fmt.Sprintf("queue op[%d]", op))
+	}
+	return nil
+}
+
+//appendState append the operation to the state stream and apply it,
+//the state is checkpointed every queueCheckpointInterval operations
+func (queue *Queue) appendState(op byte, deadline int64, offsets []int64) error {
+	var data = make([]byte, 9+len(offsets)*8)
+	data[0] = op
+	binary.BigEndian.PutUint64(data[1:], uint64(deadline))
+	for i, offset := range offsets {
+		binary.BigEndian.PutUint64(data[9+i*8:], uint64(offset))
+	}
+	result, err := queue.sstore.AppendRecord(queue.stateStreamID, data, -1)
+	if err != nil {
+		return err
+	}
+	if err := queue.apply(data); err != nil {
+		return err
+	}
+	if op == queueOpCheckpoint {
+		//replay begins at the checkpoint,GC keeps the state from it
+		return queue.sstore.putConsumer(queueConsumer, queue.stateStreamID, result.Begin)
+	}
+	if queue.ops >= queueCheckpointInterval {
+		return queue.checkpoint()
+	}
+	return nil
+}
+
+//checkpoint append the whole state to the state stream
+func (queue *Queue) checkpoint() error {
+	var begins = make([]int64, 0, len(queue.leases))
+	for begin := range queue.leases {
+		begins = append(begins, begin)
+	}
+	sort.Slice(begins, func(i, j int) bool {
+		return begins[i] < begins[j]
+	})
+	var offsets = make([]int64, 0, len(begins)*3)
+	for _, begin := range begins {
+		lease := queue.leases[begin]
+		offsets = append(offsets, begin, lease.end, lease.deadline)
+	}
+	return queue.appendState(queueOpCheckpoint, queue.next, offsets)
+}
+
+//Push append the data to the queue as a record
+func (queue *Queue) Push(data []byte) (AppendResult, error) {
+	return queue.sstore.AppendRecord(queue.streamID, data, -1)
+}
+
+//Lease lease at most max records,the records of expired leases first
+//and then the records never leased.it returns none if no record is available.
+//the records deleted by GC are dropped,the expired leases of them are acked
+func (queue *Queue) Lease(max int) ([]Lease, error) {
+	if max <= 0 {
+		return nil, nil
+	}
+	queue.l.Lock()
+	defer queue.l.Unlock()
+	now := queue.clock()
+	var expired []int64
+	for begin, lease := range queue.leases {
+		if lease.deadline <= now.UnixNano() {
+			expired = append(expired, begin)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i] < expired[j]
+	})
+	var records []*Record
+	var dropped []int64
+	for _, begin := range expired {
+		if len(records) == max {
+			break
+		}
+		record, err := queue.readRecord(begin)
+		if errors.Cause(err) == ErrEntryGC {
+			dropped = append(dropped, begin)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if len(dropped) > 0 {
+		if err := queue.appendState(queueOpAck, 0, dropped); err != nil {
+			return nil, err
+		}
+	}
+	for offset := queue.next; len(records) < max; {
+		record, err := queue.readRecord(offset)
+		if err == io.EOF {
+			break
+		} else if errors.Cause(err) == ErrEntryGC {
+			offset = queue.begin()
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+		offset = record.End
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	deadline := now.Add(queue.visibility)
+	var offsets = make([]int64, 0, len(records)*2)
+	for _, record := range records {
+		offsets = append(offsets, record.Begin, record.End)
+	}
+	if err := queue.appendState(queueOpLease, deadline.UnixNano(), offsets); err != nil {
+		return nil, err
+	}
+	var leases = make([]Lease, 0, len(records))
+	for _, record := range records {
+		leases = append(leases, Lease{Record: *record, Deadline: deadline})
+	}
+	return leases, nil
+}
+
+//readRecord read the record begins at offset,
+//it returns io.EOF if offset is the end of stream
+func (queue *Queue) readRecord(offset int64) (*Record, error) {
+	if queue.reader == nil {
+		reader, err := queue.sstore.RecordReader(queue.streamID)
+		if err != nil {
+			if errors.Cause(err) == ErrNoFindStream {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		queue.reader = reader
+	}
+	if offset < queue.begin() {
+		return nil, errors.Wrapf(ErrEntryGC, "stream[%d] offset[%d]", queue.streamID, offset)
+	}
+	if end, _ := queue.sstore.End(queue.streamID); offset >= end {
+		return nil, io.EOF
+	}
+	if queue.reader.Offset() != offset {
+		if err := queue.reader.seek(offset); err != nil {
+			return nil, err
+		}
+	}
+	return queue.reader.Next()
+}
+
+//begin return the begin of stream,the end if all the data is deleted by GC
+func (queue *Queue) begin() int64 {
+	begin, ok := queue.sstore.Begin(queue.streamID)
+	if ok == false {
+		begin, _ = queue.sstore.End(queue.streamID)
+	}
+	return begin
+}
+
+//Ack ack the leased records by the begins of them,
+//they are never redelivered.it returns ErrLease if any one is not leased
+func (queue *Queue) Ack(begins ...int64) error {
+	queue.l.Lock()
+	defer queue.l.Unlock()
+	for _, begin := range begins {
+		if _, ok := queue.leases[begin]; ok == false {
+			return errors.Wrapf(ErrLease, "stream[%d] offset[%d]", queue.streamID, begin)
+		}
+	}
+	return queue.appendState(queueOpAck, 0, begins)
+}
+
+//Pending return the count of the records leased and not acked
+func (queue *Queue) Pending() int {
+	queue.l.Lock()
+	defer queue.l.Unlock()
+	return len(queue.leases)
+}
@@ -16,22 +16,38 @@ package sstore
 import (
 	"github.com/pkg/errors"
 	"io"
+	"runtime"
 )
 
+//errGap is returned by the reader stopping at the gap deleted by compaction
+var errGap = errors.New("gap deleted by compaction")
+
 type reader struct {
 	offset   int64
 	streamID int64
 	index    *offsetIndex
 	endMap   *int64LockMap
+	//item is the segment item being read,the segment is pinned until the reader
+	//leaves it,so the data replaced by compaction is read consistently
+	item offsetItem
+	//stopAtGap make Read return errGap at the gap instead of skipping it,
+	//gap is the offset of the data kept after the gap
+	stopAtGap bool
+	gap       int64
 }
 
 func newReader(streamID int64, index *offsetIndex, endMap *int64LockMap) *reader {
-	return &reader{
+	r := &reader{
 		offset:   0,
 		streamID: streamID,
 		index:    index,
 		endMap:   endMap,
 	}
+	//the reader is not closed,the segment pinned is released when it is collected
+	runtime.SetFinalizer(r, func(r *reader) {
+		r.release()
+	})
+	return r
 }
 
 func (r *reader) Seek(offset int64, whence int) (int64, error) {
@@ -56,13 +72,51 @@ func (r *reader) Seek(offset int64, whence int) (int64, error) {
 	return offset, nil
 }
 
+//find return the item of the data at the offset of reader
+func (r *reader) find() (offsetItem, error) {
+	if r.item.segment != nil {
+		if r.offset >= r.item.begin && r.offset < r.item.end {
+			return r.item, nil
+		}
+		r.release()
+	}
+	item, err := r.index.find(r.offset)
+	if err != nil {
+		return item, err
+	}
+	if item.segment != nil && r.offset < item.end {
+		if item.segment.refInc() < 0 {
+			return offsetIndexNoFind, errors.WithStack(ErrOffset)
+		}
+		r.item = item
+	}
+	return item, nil
+}
+
+//release the segment pinned
+func (r *reader) release() {
+	if r.item.segment != nil {
+		r.item.segment.refDec()
+		r.item = offsetItem{}
+	}
+}
+
+//Read the data of stream,the gaps deleted by compaction are skipped
+//and Read returns the data before the gap first
 func (r *reader) Read(p []byte) (int, error) {
 	buf := p
 	var ret int
 	for len(buf) > 0 {
-		item, err := r.index.find(r.offset)
+		//the last item may be a segment,it can't tell the end of stream
+		if end, ok := r.endMap.get(r.streamID); ok && r.offset >= end {
+			if ret == 0 {
+				return 0, io.EOF
+			}
+			return ret, nil
+		}
+		item, err := r.find()
 		if err != nil {
-			return 0, err
+			return ret, err
 		}
 		if item.mStream != nil {
 			n, err := item.mStream.ReadAt(buf, r.offset)
@@ -79,11 +133,25 @@ func (r *reader) Read(p []byte) (int, error) {
 			ret += n
 			r.offset += int64(n)
 		} else if item.segment != nil {
-			if item.segment.refInc() < 0 {
-				return ret, errors.WithStack(ErrOffset)
+			//the mStream of the new data is not indexed yet
+			if r.offset >= item.end {
+				if ret == 0 {
+					return 0, io.EOF
+				}
+				return ret, nil
+			}
+			if kept, _ := item.segment.meta.OffSetInfos[r.streamID].kept(r.offset); kept != r.offset {
+				if ret > 0 {
+					return ret, nil
+				}
+				if r.stopAtGap {
+					r.gap = kept
+					return 0, errGap
+				}
+				r.offset = kept
+				continue
 			}
 			n, err := item.segment.Reader(r.streamID).ReadAt(buf, r.offset)
-			item.segment.refDec()
 			if err != nil {
 				if err == io.EOF {
 					if n == 0 {
@@ -0,0 +1,417 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"hash/crc32"
+	"io"
+	"sort"
+	"sync"
+)
+
+//the append modes of streams,the mode of stream is set by the first append
+//of it and the appends of other modes are rejected with ErrStreamMode
+const (
+	streamModeRaw    = 0
+	streamModeRecord = 1
+	streamModeKeyed  = 2
+)
+
+//recordMagic is the first 4 bytes of record frame
+const recordMagic uint32 = 0x52454344
+
+//recordHeaderSize is the size of magic,payload length,payload crc
+const recordHeaderSize = 12
+
+//recordIndexInterval is the count of records between the items of sparse record index
+const recordIndexInterval = 128
+
+//recordIndexItem is the offset of the record of Seq
+type recordIndexItem struct {
+	Seq    int64 `json:"seq"`
+	Offset int64 `json:"offset"`
+}
+
+//Record is a record of the record-framed stream
+type Record struct {
+	Data []byte
+	//Begin,End are the offsets of the frame of record in the stream
+	Begin int64
+	End   int64
+}
+
+//encodeRecord frame the data as a record
+func encodeRecord(data []byte) []byte {
+	var frame = make([]byte, recordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(frame, recordMagic)
+	binary.BigEndian.PutUint32(frame[4:], uint32(len(data)))
+	binary.BigEndian.PutUint32(frame[8:], crc32.ChecksumIEEE(data))
+	copy(frame[recordHeaderSize:], data)
+	return frame
+}
+
+//modeTable is the append modes of streams
+type modeTable struct {
+	l     sync.Mutex
+	modes map[int64]byte
+}
+
+func newModeTable() *modeTable {
+	return &modeTable{
+		modes: make(map[int64]byte),
+	}
+}
+
+func (table *modeTable) get(streamID int64) byte {
+	table.l.Lock()
+	defer table.l.Unlock()
+	return table.modes[streamID]
+}
+
+func (table *modeTable) set(streamID int64, mode byte) {
+	table.l.Lock()
+	defer table.l.Unlock()
+	table.modes[streamID] = mode
+}
+
+//clone return the copy of modes to persist
+func (table *modeTable) clone() map[int64]byte {
+	table.l.Lock()
+	defer table.l.Unlock()
+	var modes = make(map[int64]byte, len(table.modes))
+	for streamID, mode := range table.modes {
+		modes[streamID] = mode
+	}
+	return modes
+}
+
+//load the modes persisted
+func (table *modeTable) load(modes map[int64]byte) {
+	table.l.Lock()
+	defer table.l.Unlock()
+	table.modes = make(map[int64]byte, len(modes))
+	for streamID, mode := range modes {
+		table.modes[streamID] = mode
+	}
+}
+
+//merge the modes of segment flushed by the owner,the mode of stream never changes
+func (table *modeTable) merge(modes map[int64]byte) {
+	table.l.Lock()
+	defer table.l.Unlock()
+	for streamID, mode := range modes {
+		table.modes[streamID] = mode
+	}
+}
+
+//AppendRecord append the data as one record to the stream,the stream is in
+//record mode after the first record appended,the raw appends to it are rejected
+//with ErrStreamMode.the stream with raw data can't be in record mode.
+//the result is the offsets of the frame of record
+func (sstore *SStore) AppendRecord(streamID int64, data []byte, offset int64) (AppendResult, error) {
+	var err error
+	var result AppendResult
+	sstore.syncCall(func(done func()) {
+		sstore.AsyncAppendRecord(streamID, data, offset, func(r AppendResult, e error) {
+			result, err = r, e
+			done()
+		})
+	})
+	return result, err
+}
+
+//AsyncAppendRecord async append the data as one record to the stream
+func (sstore *SStore) AsyncAppendRecord(streamID int64, data []byte, offset int64,
+	cb func(result AppendResult, err error)) {
+	sstore.asyncAppendFrame(streamModeRecord, streamID, encodeRecord(data), offset, cb)
+}
+
+//asyncAppendFrame append the record frame to the stream of mode
+func (sstore *SStore) asyncAppendFrame(mode byte, streamID int64, frame []byte, offset int64,
+	cb func(result AppendResult, err error)) {
+	sstore.asyncAppendBatchEntry(recordStreamID, func(e *entry) {
+		e.mode = mode
+	}, []AppendOp{{
+		StreamID: streamID,
+		Data:     frame,
+		Offset:   offset,
+	}}, func(results []AppendResult, err error) {
+		if err != nil {
+			cb(AppendResult{EntryID: -1, Begin: -1, End: -1}, err)
+			return
+		}
+		cb(results[0], nil)
+	})
+}
+
+//RecordReader read the whole records of the record-framed stream
+type RecordReader struct {
+	sstore   *SStore
+	streamID int64
+	reader   *reader
+	buffer   *bufio.Reader
+	offset   int64
+}
+
+//RecordReader create RecordReader of the stream,it reads from the begin of stream.
+//the records deleted by compaction are skipped
+func (sstore *SStore) RecordReader(streamID int64) (*RecordReader, error) {
+	reader, err := sstore.indexTable.reader(streamID)
+	if err != nil {
+		return nil, err
+	}
+	//the records after the gap begin at the end of it
+	reader.stopAtGap = true
+	recordReader := &RecordReader{
+		sstore:   sstore,
+		streamID: streamID,
+		reader:   reader,
+		buffer:   bufio.NewReaderSize(reader, 64*1024),
+	}
+	begin, _ := sstore.Begin(streamID)
+	if err := recordReader.seek(begin); err != nil {
+		return nil, err
+	}
+	return recordReader, nil
+}
+
+//Offset return the offset of the next record
+func (rr *RecordReader) Offset() int64 {
+	return rr.offset
+}
+
+func (rr *RecordReader) seek(offset int64) error {
+	if _, err := rr.reader.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	rr.buffer.Reset(rr.reader)
+	rr.offset = offset
+	return nil
+}
+
+//Next return the next record,it returns io.EOF at the end of stream
+//and the next records are read after they are appended
+func (rr *RecordReader) Next() (*Record, error) {
+	var header [recordHeaderSize]byte
+	if n, err := io.ReadFull(rr.buffer, header[:]); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		if err == errGap && n == 0 {
+			if err := rr.seek(rr.reader.gap); err != nil {
+				return nil, err
+			}
+			return rr.Next()
+		}
+		//the records are appended whole,the header must be complete
+		_ = rr.seek(rr.offset)
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.WithMessage(ErrRecord,
+				fmt.Sprintf("stream[%d] offset[%d] header %d bytes", rr.streamID, rr.offset, n))
+		}
+		return nil, err
+	}
+	record, err := rr.readRecord(header[:])
+	if err != nil {
+		_ = rr.seek(rr.offset)
+		return nil, err
+	}
+	rr.offset = record.End
+	return record, nil
+}
+
+//readRecord read the payload of the record of the header at rr.offset
+func (rr *RecordReader) readRecord(header []byte) (*Record, error) {
+	if magic := binary.BigEndian.Uint32(header); magic != recordMagic {
+		return nil, errors.WithMessage(ErrRecord,
+			fmt.Sprintf("stream[%d] offset[%d] magic [%x]", rr.streamID, rr.offset, magic))
+	}
+	length := int64(binary.BigEndian.Uint32(header[4:]))
+	if end, _ := rr.sstore.End(rr.streamID); rr.offset+recordHeaderSize+length > end {
+		return nil, errors.WithMessage(ErrRecord,
+			fmt.Sprintf("stream[%d] offset[%d] length[%d] beyond end[%d]", rr.streamID, rr.offset, length, end))
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(rr.buffer, data); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if crc := binary.BigEndian.Uint32(header[8:]); crc != crc32.ChecksumIEEE(data) {
+		return nil, errors.WithMessage(ErrRecord,
+			fmt.Sprintf("stream[%d] offset[%d] crc error", rr.streamID, rr.offset))
+	}
+	return &Record{
+		Data:  data,
+		Begin: rr.offset,
+		End:   rr.offset + recordHeaderSize + length,
+	}, nil
+}
+
+//SeekRecord seek to the first record boundary at or after the offset,
+//the boundary is found by the sparse record index and the headers of the
+//records after it,so the payload with the frames nested is not a boundary
+func (rr *RecordReader) SeekRecord(offset int64) error {
+	if begin, _ := rr.sstore.Begin(rr.streamID); offset < begin {
+		offset = begin
+	}
+	if end, _ := rr.sstore.End(rr.streamID); offset > end {
+		offset = end
+	}
+	seq, begin, err := rr.sstore.RecordAt(rr.streamID, offset)
+	if err != nil {
+		return err
+	}
+	if begin < offset {
+		//offset is in the record of seq,the next one begins at the end of it
+		if begin, err = rr.sstore.OffsetOfRecord(rr.streamID, seq+1); err != nil {
+			return err
+		}
+	}
+	return rr.seek(begin)
+}
+
+//checkRecordMode return ErrStreamMode if the stream is not in record mode,
+//only the records of it are counted and indexed
+func (sstore *SStore) checkRecordMode(streamID int64) error {
+	if sstore.committer.modes.get(streamID) == streamModeRaw {
+		return errors.Wrapf(ErrStreamMode, "stream[%d] is not in record mode", streamID)
+	}
+	return nil
+}
+
+//OffsetOfRecord return the offset of the record n of the stream,n starts from 0.
+//it returns the end of stream if n is the count of records,and the offset of the
+//next record kept if the record n is deleted by compaction.
+//it returns ErrStreamMode if the stream is not in record mode
+func (sstore *SStore) OffsetOfRecord(streamID int64, n int64) (int64, error) {
+	offsetIndex := sstore.indexTable.get(streamID)
+	if offsetIndex == nil {
+		return 0, errors.Wrapf(ErrNoFindStream, "stream[%d]", streamID)
+	}
+	if err := sstore.checkRecordMode(streamID); err != nil {
+		return 0, err
+	}
+	end, _ := sstore.endMap.get(streamID)
+	count, _ := sstore.recordMap.get(streamID)
+	if n == count {
+		return end, nil
+	}
+	items := offsetIndex.recordIndex()
+	i := sort.Search(len(items), func(i int) bool {
+		return items[i].Seq > n
+	}) - 1
+	if begin, ok := offsetIndex.begin(); ok && i < 0 && n >= 0 && len(items) > 0 {
+		//the records at the begin are deleted by compaction
+		if kept := offsetIndex.kept(begin); kept > begin && kept == items[0].Offset {
+			i = 0
+		}
+	}
+	if n < 0 || n > count || i < 0 {
+		return 0, errors.Wrapf(ErrOffset, "stream[%d] record[%d] count[%d]", streamID, n, count)
+	}
+	var offset = items[i].Offset
+	err := sstore.scanRecords(streamID, items, i, func(seq int64, begin int64, _ int64) bool {
+		offset = begin
+		return seq < n
+	})
+	return offset, err
+}
+
+//RecordAt return the sequence and the offset of the record which
+//the offset is in,offset is rounded down to the boundary of record,
+//or up to the next record kept if it is in the gap deleted by compaction.
+//it returns the count of records and the end if offset is the end of stream.
+//it returns ErrStreamMode if the stream is not in record mode
+func (sstore *SStore) RecordAt(streamID int64, offset int64) (int64, int64, error) {
+	offsetIndex := sstore.indexTable.get(streamID)
+	if offsetIndex == nil {
+		return 0, 0, errors.Wrapf(ErrNoFindStream, "stream[%d]", streamID)
+	}
+	if err := sstore.checkRecordMode(streamID); err != nil {
+		return 0, 0, err
+	}
+	end, _ := sstore.endMap.get(streamID)
+	count, _ := sstore.recordMap.get(streamID)
+	if offset >= 0 && offset < end {
+		offset = offsetIndex.kept(offset)
+	}
+	if offset == end {
+		return count, end, nil
+	}
+	items := offsetIndex.recordIndex()
+	i := sort.Search(len(items), func(i int) bool {
+		return items[i].Offset > offset
+	}) - 1
+	if offset > end || i < 0 {
+		return 0, 0, errors.Wrapf(ErrOffset, "stream[%d] offset[%d] end[%d]", streamID, offset, end)
+	}
+	var seq, begin int64
+	err := sstore.scanRecords(streamID, items, i, func(s int64, b int64, e int64) bool {
+		seq, begin = s, b
+		return e <= offset
+	})
+	return seq, begin, err
+}
+
+//scanRecords pass the records from the item i of record index to cb by the headers
+//of them with the sequences.the record after the gap deleted by compaction has the
+//item of it,the end of stream is passed as the record of the count of records.
+//it stops when cb returns false
+func (sstore *SStore) scanRecords(streamID int64, items []recordIndexItem, i int,
+	cb func(seq int64, begin int64, end int64) bool) error {
+	reader, err := sstore.indexTable.reader(streamID)
+	if err != nil {
+		return err
+	}
+	reader.stopAtGap = true
+	end, _ := sstore.endMap.get(streamID)
+	count, _ := sstore.recordMap.get(streamID)
+	var header [recordHeaderSize]byte
+	for seq, offset := items[i].Seq, items[i].Offset; ; seq++ {
+		if offset >= end {
+			cb(count, end, end)
+			return nil
+		}
+		if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if n, err := io.ReadFull(reader, header[:]); err == errGap && n == 0 {
+			offset = reader.gap
+			j := sort.Search(len(items), func(j int) bool {
+				return items[j].Offset >= offset
+			})
+			if offset < end && (j == len(items) || items[j].Offset != offset) {
+				return errors.WithMessage(ErrRecord,
+					fmt.Sprintf("stream[%d] offset[%d] no record index after gap", streamID, offset))
+			} else if j < len(items) {
+				seq = items[j].Seq - 1
+			}
+			continue
+		} else if err != nil {
+			return errors.WithStack(err)
+		}
+		if magic := binary.BigEndian.Uint32(header[:]); magic != recordMagic {
+			return errors.WithMessage(ErrRecord,
+				fmt.Sprintf("stream[%d] offset[%d] magic [%x]", streamID, offset, magic))
+		}
+		recordEnd := offset + recordHeaderSize + int64(binary.BigEndian.Uint32(header[4:]))
+		if cb(seq, offset, recordEnd) == false {
+			return nil
+		}
+		offset = recordEnd
+	}
+}
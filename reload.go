@@ -18,6 +18,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 func mkdir(dir string) error {
@@ -34,23 +35,29 @@ func mkdir(dir string) error {
 
 //reload segment,journal,index
 func reload(sStore *SStore) error {
+	begin := time.Now()
+	readOnly := sStore.options.ReadOnly
 	for _, dir := range []string{
 		sStore.options.WalDir,
 		sStore.options.ManifestDir,
 		sStore.options.SegmentDir} {
+		if readOnly {
+			break
+		}
 		if err := mkdir(dir); err != nil {
 			return err
 		}
 	}
 	manifest, err := openManifest(sStore.options.ManifestDir,
 		sStore.options.SegmentDir,
-		sStore.options.WalDir)
+		sStore.options.WalDir,
+		readOnly)
 	if err != nil {
 		return err
 	}
 	sStore.files = manifest
 
-	mStreamTable := newMStreamTable(sStore.endMap, sStore.options.BlockSize, 128)
+	mStreamTable := newMStreamTable(sStore.endMap, sStore.recordMap, sStore.options.BlockSize, 128)
 	commitQueue := newEntryQueue(sStore.options.EntryQueueCap)
 	committer := newCommitter(sStore.options,
 		sStore.endWatchers,
@@ -60,36 +67,81 @@ func reload(sStore *SStore) error {
 		mStreamTable,
 		commitQueue,
 		manifest,
+		sStore.commitNotify,
 		sStore.options.BlockSize)
 	sStore.committer = committer
 
 	sStore.committer.start()
 	sStore.files.start()
-	sStore.endWatchers.start()
 
 	//rebuild segment index
 	segmentFiles := manifest.getSegmentFiles()
+	var lastMeta *segmentMeta
 	for _, file := range segmentFiles {
 		segment, err := openSegment(filepath.Join(sStore.options.SegmentDir, file))
 		if err != nil {
 			return err
 		}
+		if segment.meta.Compacted {
+			//the data of it is before the ends of streams,no entry is after it
+			segment.refInc()
+			sStore.segments[file] = segment
+			if _, err := sStore.indexTable.compact(segment); err != nil {
+				return err
+			}
+			continue
+		}
 		for _, info := range segment.meta.OffSetInfos {
 			sStore.endMap.set(info.StreamID, info.End, segment.meta.Ver)
+			sStore.recordMap.set(info.StreamID, info.Records, segment.meta.Ver)
 		}
 		if segment.meta.LastEntryID <= sStore.entryID {
 			return errors.Errorf("segment meta LastEntryID[%d] error",
 				segment.meta.LastEntryID)
 		}
 		sStore.entryID = segment.meta.LastEntryID
+		lastMeta = segment.meta
+		//the reference of store,it is released when GC deletes the segment
+		segment.refInc()
 		sStore.segments[file] = segment
 		if err := sStore.indexTable.update1(segment); err != nil {
 			return err
 		}
 	}
 
+	committer.lastEntryID = sStore.entryID
+	if lastMeta != nil {
+		committer.producers.load(lastMeta.Producers)
+		committer.epochs.load(lastMeta.Epochs)
+		committer.modes.load(lastMeta.Modes)
+		committer.consumers.load(lastMeta.Consumers)
+	}
+
 	//replay entries in the journal
 	walFiles := manifest.getWalFiles()
+	segmentEntryID := sStore.entryID
+	var recovered = func() {
+		sStore.options.eventListener().OnRecovery(RecoveryEvent{
+			Segments:    segmentFiles,
+			Journals:    walFiles,
+			Entries:     sStore.entryID - segmentEntryID,
+			LastEntryID: sStore.entryID,
+			Duration:    time.Since(begin),
+		})
+	}
+	if readOnly {
+		//the journals and segments belong to the owner,
+		//only follow them without writing or deleting anything
+		sStore.tailer = newJournalTailer(sStore)
+		if err := sStore.tailer.tail(walFiles); err != nil {
+			return err
+		}
+		if sStore.options.RefreshInterval > 0 {
+			sStore.tailer.start(sStore.options.RefreshInterval)
+		}
+		recovered()
+		return nil
+	}
 	var cb = func(int64, error) {}
 	for _, filename := range walFiles {
 		journal, err := openJournal(filepath.Join(sStore.options.WalDir, filename))
@@ -123,6 +175,10 @@ func reload(sStore *SStore) error {
 		}
 	}
 
+	//make the entries replayed visible before Open returns
+	committer.barrier(nil)
+	recovered()
+
 	//create journal writer
 	var w *journal
 	if len(walFiles) > 0 {
@@ -144,7 +200,9 @@ func reload(sStore *SStore) error {
 		}
 	}
 	sStore.wWriter = newWWriter(w, sStore.entryQueue,
-		sStore.committer.queue, sStore.files, sStore.options.MaxWalSize)
+		sStore.committer.queue, sStore.files, sStore.endMap,
+		sStore.committer.producers, sStore.committer.epochs, sStore.committer.modes,
+		sStore.options.Clock, sStore.options.eventListener(), sStore.options.MaxWalSize)
 	sStore.wWriter.start()
 
 	//clear dead journal
@@ -0,0 +1,403 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//the messages from leader to follower,
+//entryMessage is followed by the entry encoded as in the journal,
+//snapshotMessage is followed by the snapshot written by WriteSnapshot
+const (
+	entryMessage    byte = 1
+	snapshotMessage byte = 2
+)
+
+//errEntryGap means the entries wanted are not in the journal
+var errEntryGap = errors.New("entry gap")
+
+//ReplicationServer ship the journal entries of the leader store
+//to the followers
+type ReplicationServer struct {
+	sstore   *SStore
+	listener net.Listener
+	l        sync.Mutex
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+	c        chan interface{}
+}
+
+//ServeReplication serve the followers on the listener,
+//the ReplicationServer closes the listener when it is closed
+func (sstore *SStore) ServeReplication(listener net.Listener) (*ReplicationServer, error) {
+	if sstore.options.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	server := &ReplicationServer{
+		sstore:   sstore,
+		listener: listener,
+		conns:    map[net.Conn]struct{}{},
+		c:        make(chan interface{}),
+	}
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				select {
+				case <-server.c:
+				default:
+					log.Printf("replication accept failed %+v", err)
+				}
+				return
+			}
+			server.l.Lock()
+			server.conns[conn] = struct{}{}
+			server.l.Unlock()
+			server.wg.Add(1)
+			go func() {
+				defer server.wg.Done()
+				if err := server.serve(conn); err != nil {
+					select {
+					case <-server.c:
+					default:
+						log.Printf("replication to %s failed %+v", conn.RemoteAddr(), err)
+					}
+				}
+				server.l.Lock()
+				delete(server.conns, conn)
+				server.l.Unlock()
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return server, nil
+}
+
+//Addr return the address of listener
+func (server *ReplicationServer) Addr() net.Addr {
+	return server.listener.Addr()
+}
+
+//Close stop the server and close the connections of followers
+func (server *ReplicationServer) Close() error {
+	close(server.c)
+	err := server.listener.Close()
+	server.l.Lock()
+	for conn := range server.conns {
+		_ = conn.Close()
+	}
+	server.l.Unlock()
+	server.wg.Wait()
+	return errors.WithStack(err)
+}
+
+//serve ship the entries from the ID the follower sent
+func (server *ReplicationServer) serve(conn net.Conn) error {
+	var next int64
+	if err := binary.Read(conn, binary.BigEndian, &next); err != nil {
+		return errors.WithStack(err)
+	}
+	if last := atomic.LoadInt64(&server.sstore.entryID); next > last+1 {
+		return errors.Errorf("follower entry ID[%d] is ahead of leader[%d]", next-1, last)
+	}
+	shipper := &journalShipper{sstore: server.sstore, next: next}
+	writer := bufio.NewWriterSize(conn, 1024*1024)
+	for {
+		wait := server.sstore.commitNotify.wait()
+		count, err := shipper.ship(func(e *entry) error {
+			if err := writer.WriteByte(entryMessage); err != nil {
+				return errors.WithStack(err)
+			}
+			return e.write(writer)
+		})
+		if err == errEntryGap {
+			//the entries are deleted by gcWal,send the snapshot instead
+			if err := writer.WriteByte(snapshotMessage); err != nil {
+				return errors.WithStack(err)
+			}
+			header, err := server.sstore.writeSnapshot(writer)
+			if err != nil {
+				return err
+			}
+			shipper.next = header.LastEntryID + 1
+			shipper.filename = ""
+			shipper.offset = 0
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := writer.Flush(); err != nil {
+			return errors.WithStack(err)
+		}
+		if count > 0 {
+			continue
+		}
+		select {
+		case <-server.c:
+			return nil
+		case <-wait:
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+//journalShipper read the entries from the journals of leader,
+//it remembers the position of the last entry shipped
+type journalShipper struct {
+	sstore   *SStore
+	next     int64
+	filename string
+	offset   int64
+}
+
+//errStopShip stop ship the entries,the entry passed to cb is shipped,
+//errEntryPending stop before the entry passed to cb
+var (
+	errStopShip     = errors.New("stop ship")
+	errEntryPending = errors.New("entry pending")
+)
+
+//ship pass the entries from next ID to cb,it returns the count of entries.
+//it returns errEntryGap if the entries have been deleted
+func (shipper *journalShipper) ship(cb func(e *entry) error) (int, error) {
+	sstore := shipper.sstore
+	//the entries flushed to segments were in the journals before
+	flushed := sstore.lastSegmentEntryID()
+	walFiles := sstore.files.getWalFiles()
+	var index int
+	if shipper.filename != "" {
+		last, err := parseFilenameIndex(shipper.filename)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		for ; index < len(walFiles); index++ {
+			current, err := parseFilenameIndex(walFiles[index])
+			if err != nil {
+				return 0, errors.WithStack(err)
+			}
+			if current >= last {
+				break
+			}
+		}
+	} else {
+		for ; index < len(walFiles); index++ {
+			header, err := sstore.files.getWalHeader(walFiles[index])
+			if err != nil || header.Old == false || header.LastEntryID >= shipper.next {
+				break
+			}
+		}
+	}
+	var count int
+	for ; index < len(walFiles); index++ {
+		filename := walFiles[index]
+		var offset int64
+		if filename == shipper.filename {
+			offset = shipper.offset
+		}
+		journal, err := openJournalReadOnly(filepath.Join(sstore.options.WalDir, filename))
+		if err != nil {
+			if os.IsNotExist(errors.Cause(err)) {
+				return count, errEntryGap
+			}
+			return count, err
+		}
+		offset, err = journal.ReadFrom(offset, func(e *entry) error {
+			if e.ID < shipper.next {
+				return nil
+			} else if e.ID > shipper.next {
+				return errEntryGap
+			}
+			err := cb(e)
+			if err == errEntryPending {
+				return err
+			} else if err != nil && err != errStopShip {
+				return err
+			}
+			shipper.next++
+			count++
+			return err
+		})
+		_ = journal.Close()
+		if err == errStopShip || err == errEntryPending {
+			shipper.filename = filename
+			shipper.offset = offset
+			return count, nil
+		} else if err == errEntryGap {
+			//the last journal may be flushing when the next one is created,
+			//read it again later
+			if shipper.filename != "" && containsString(walFiles, shipper.filename) {
+				return count, nil
+			}
+			return count, err
+		} else if err != nil {
+			return count, err
+		}
+		shipper.filename = filename
+		shipper.offset = offset
+	}
+	if flushed >= shipper.next {
+		return count, errEntryGap
+	}
+	return count, nil
+}
+
+//lastSegmentEntryID return the greatest LastEntryID of the segments,
+//the compacted segment is appended last with the LastEntryID of an older one
+func (sstore *SStore) lastSegmentEntryID() int64 {
+	var lastEntryID int64
+	for _, filename := range sstore.files.getSegmentFiles() {
+		segment := sstore.committer.getSegment(filename)
+		if segment != nil && segment.lastEntryID() > lastEntryID {
+			lastEntryID = segment.lastEntryID()
+		}
+	}
+	return lastEntryID
+}
+
+//Follower replicate the entries of leader to the store
+type Follower struct {
+	sstore *SStore
+	addr   string
+	l      sync.Mutex
+	conn   net.Conn
+	c      chan interface{}
+	s      chan interface{}
+}
+
+//Follow replicate the store from the leader serving on addr,
+//it reconnects to the leader until the Follower is closed.
+//the store rejects the appends while following
+func (sstore *SStore) Follow(addr string) (*Follower, error) {
+	if sstore.options.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if atomic.CompareAndSwapInt32(&sstore.following, 0, 1) == false {
+		return nil, errors.Errorf("SStore is following")
+	}
+	follower := &Follower{
+		sstore: sstore,
+		addr:   addr,
+		c:      make(chan interface{}),
+		s:      make(chan interface{}),
+	}
+	go follower.run()
+	return follower, nil
+}
+
+func (follower *Follower) run() {
+	defer close(follower.s)
+	for {
+		conn, err := net.DialTimeout("tcp", follower.addr, time.Second*3)
+		if err == nil {
+			follower.l.Lock()
+			select {
+			case <-follower.c:
+				follower.l.Unlock()
+				_ = conn.Close()
+				return
+			default:
+			}
+			follower.conn = conn
+			follower.l.Unlock()
+			err = follower.replicate(conn)
+			_ = conn.Close()
+		}
+		select {
+		case <-follower.c:
+			return
+		default:
+		}
+		log.Printf("replicate from %s failed %+v", follower.addr, err)
+		select {
+		case <-follower.c:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+//replicate apply the entries and snapshots from the leader
+func (follower *Follower) replicate(conn net.Conn) error {
+	sstore := follower.sstore
+	next := atomic.LoadInt64(&sstore.entryID) + 1
+	if err := binary.Write(conn, binary.BigEndian, next); err != nil {
+		return errors.WithStack(err)
+	}
+	reader := bufio.NewReaderSize(conn, 1024*1024)
+	for {
+		typ, err := reader.ReadByte()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		switch typ {
+		case entryMessage:
+			e, err := decodeEntry(reader)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if err := sstore.appendReplicated(e); err != nil {
+				return err
+			}
+		case snapshotMessage:
+			if err := sstore.RestoreSnapshot(reader); err != nil {
+				return err
+			}
+		default:
+			return errors.Errorf("unknown message type %d", typ)
+		}
+	}
+}
+
+//Close stop replicating,the store accepts appends after it
+func (follower *Follower) Close() error {
+	close(follower.c)
+	follower.l.Lock()
+	if follower.conn != nil {
+		_ = follower.conn.Close()
+	}
+	follower.l.Unlock()
+	<-follower.s
+	atomic.StoreInt32(&follower.sstore.following, 0)
+	return nil
+}
+
+//appendReplicated append the entry of leader with the ID of it
+func (sstore *SStore) appendReplicated(e *entry) error {
+	sstore.restoreLocker.RLock()
+	defer sstore.restoreLocker.RUnlock()
+	if sstore.broken != nil {
+		return sstore.broken
+	}
+	if e.ID != sstore.entryID+1 {
+		return errors.WithMessage(ErrWal,
+			fmt.Sprintf("e.ID[%d] sStore.entryID+1[%d]", e.ID, sstore.entryID+1))
+	}
+	atomic.StoreInt64(&sstore.entryID, e.ID)
+	e.cb = func(int64, error) {}
+	sstore.entryQueue.put(e)
+	return nil
+}
@@ -24,16 +24,72 @@ import (
 	"log"
 	"math"
 	"os"
+	"sort"
 	"sync"
 	"time"
 )
 
+//offsetExtent is a range of the data of stream kept by compaction,
+//Pos is the position of the data of it after offsetInfo.Offset
+type offsetExtent struct {
+	Begin int64 `json:"begin"`
+	End   int64 `json:"end"`
+	Pos   int64 `json:"pos"`
+}
+
 type offsetInfo struct {
 	StreamID int64  `json:"stream_id"`
 	Begin    int64  `json:"begin"`
 	Offset   int64  `json:"offset"`
 	End      int64  `json:"end"`
 	CRC      uint32 `json:"crc"`
+	//Records is the count of records of stream before End
+	Records     int64             `json:"records,omitempty"`
+	RecordIndex []recordIndexItem `json:"record_index,omitempty"`
+	//TimeIndex is the sparse index of append time,LastTS is the time of the last append
+	TimeIndex []timeIndexItem `json:"time_index,omitempty"`
+	LastTS    int64           `json:"last_ts,omitempty"`
+	//Extents are the ranges of data in [Begin,End) kept by compaction,the data
+	//between them is deleted and the offsets are never reused.empty if no gap
+	Extents []offsetExtent `json:"extents,omitempty"`
+}
+
+//size return the size of the data of stream in the segment
+func (info offsetInfo) size() int64 {
+	if len(info.Extents) == 0 {
+		return info.End - info.Begin
+	}
+	last := info.Extents[len(info.Extents)-1]
+	return last.Pos + last.End - last.Begin
+}
+
+//kept return the first offset of the data kept at or after offset and
+//the end of the extent of it.it returns End if no data is kept after offset
+func (info offsetInfo) kept(offset int64) (int64, int64) {
+	if len(info.Extents) == 0 {
+		return offset, info.End
+	}
+	i := sort.Search(len(info.Extents), func(i int) bool {
+		return info.Extents[i].End > offset
+	})
+	if i == len(info.Extents) {
+		return info.End, info.End
+	}
+	if info.Extents[i].Begin > offset {
+		offset = info.Extents[i].Begin
+	}
+	return offset, info.Extents[i].End
+}
+
+//position return the position of the data kept at offset after info.Offset
+func (info offsetInfo) position(offset int64) int64 {
+	if len(info.Extents) == 0 {
+		return offset - info.Begin
+	}
+	i := sort.Search(len(info.Extents), func(i int) bool {
+		return info.Extents[i].End > offset
+	})
+	return info.Extents[i].Pos + offset - info.Extents[i].Begin
 }
 
 type segmentMeta struct {
@@ -41,6 +97,17 @@ type segmentMeta struct {
 	GcTS        time.Time             `json:"gc_ts"`
 	LastEntryID int64                 `json:"last_entry_id"`
 	OffSetInfos map[int64]offsetInfo `json:"offset_infos"`
+	//Producers are the producers state after the entry of LastEntryID
+	Producers map[int64]producerState `json:"producers,omitempty"`
+	//Epochs are the fencing epochs of streams
+	Epochs map[int64]int64 `json:"epochs,omitempty"`
+	//Modes are the append modes of streams not raw
+	Modes map[int64]byte `json:"modes,omitempty"`
+	//Consumers are the offsets of consumers by name and StreamID
+	Consumers map[string]map[int64]int64 `json:"consumers,omitempty"`
+	//Compacted is true if the segment is the data of a stream rewritten by compaction,
+	//it replaces the data of the stream in the segments before it
+	Compacted bool `json:"compacted,omitempty"`
 }
 
 type segment struct {
@@ -50,6 +117,8 @@ type segment struct {
 	meta     *segmentMeta
 	l        *sync.RWMutex
 	delete   bool
+	//superseded are the streams of which the data is replaced by compaction
+	superseded map[int64]bool
 }
 
 func createSegment(filename string) (*segment, error) {
@@ -141,6 +210,24 @@ func (s *segment) Reader(streamID int64) *segmentReader {
 	}
 }
 
+//verify check the data of streams with the CRC in meta
+func (s *segment) verify() error {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	for streamID, info := range s.meta.OffSetInfos {
+		hash := crc32.NewIEEE()
+		reader := io.NewSectionReader(s.f, info.Offset, info.size())
+		if _, err := io.Copy(hash, reader); err != nil {
+			return errors.WithStack(err)
+		}
+		if hash.Sum32() != info.CRC {
+			return errors.Errorf("segment [%s] stream[%d] crc[%d] error,expect [%d]",
+				s.filename, streamID, hash.Sum32(), info.CRC)
+		}
+	}
+	return nil
+}
+
 func (s *segment) flushMStreamTable(table *mStreamTable) error {
 	s.l.Lock()
 	defer s.l.Unlock()
@@ -160,11 +247,23 @@ func (s *segment) flushMStreamTable(table *mStreamTable) error {
 			Begin:    mStream.begin,
 			End:      mStream.end,
 		}
+		index.Records = mStream.records
+		index.RecordIndex = mStream.getRecordIndex()
+		index.TimeIndex, index.LastTS = mStream.getTimeIndex()
 		Offset += int64(n)
 		s.meta.OffSetInfos[streamID] = index
 	}
 	s.meta.LastEntryID = table.lastEntryID
 	s.meta.GcTS = table.GcTS
+	s.meta.Producers = table.producers
+	s.meta.Epochs = table.epochs
+	s.meta.Modes = table.modes
+	s.meta.Consumers = table.consumers
+	return s.writeMeta(writer)
+}
+
+//writeMeta write the meta and the length of it after the data of streams
+func (s *segment) writeMeta(writer *bufio.Writer) error {
 	data, _ := json.Marshal(s.meta)
 	if _, err := writer.Write(data); err != nil {
 		return err
@@ -178,6 +277,22 @@ func (s *segment) flushMStreamTable(table *mStreamTable) error {
 	return nil
 }
 
+//supersede mark the data of stream replaced by compaction
+func (s *segment) supersede(streamID int64) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.superseded == nil {
+		s.superseded = make(map[int64]bool)
+	}
+	s.superseded[streamID] = true
+}
+
+func (s *segment) isSuperseded(streamID int64) bool {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	return s.superseded[streamID]
+}
+
 func (s *segment) deleteOnClose(delete bool) error {
 	s.l.Lock()
 	defer s.l.Unlock()
@@ -198,7 +313,7 @@ func (s *segment) close() error {
 		return err
 	}
 	if s.delete {
-		if err := os.Remove(s.filename); err != nil {
+		if err := os.Remove(s.filename); err != nil && os.IsNotExist(err) == false {
 			return errors.WithStack(err)
 		}
 	}
@@ -215,22 +330,29 @@ func (s *segmentReader) Seek(offset int64, whence int) (int64, error) {
 	if offset < s.indexInfo.Begin || offset >= s.indexInfo.End {
 		return 0, ErrOffset
 	}
-	offset = offset - s.indexInfo.Begin
-	return s.r.Seek(offset, whence)
+	if kept, _ := s.indexInfo.kept(offset); kept != offset {
+		return 0, ErrOffset
+	}
+	return s.r.Seek(s.indexInfo.position(offset), whence)
 }
 
+//ReadAt read the data kept at offset,it stops at the end of the extent of offset
 func (s *segmentReader) ReadAt(p []byte, offset int64) (n int, err error) {
 	if offset < s.indexInfo.Begin || offset >= s.indexInfo.End {
 		return 0, errors.Wrapf(ErrOffset,
 			fmt.Sprintf("offset[%d] begin[%d] end[%d]",
 				offset, s.indexInfo.Begin, s.indexInfo.End))
 	}
-	size := s.indexInfo.End - offset
+	kept, end := s.indexInfo.kept(offset)
+	if kept != offset {
+		return 0, errors.Wrapf(ErrOffset,
+			fmt.Sprintf("offset[%d] is deleted by compaction", offset))
+	}
+	size := end - offset
 	if int64(len(p)) > size {
 		p = p[:size]
 	}
-	offset = offset - s.indexInfo.Begin
-	return s.r.ReadAt(p, offset)
+	return s.r.ReadAt(p, s.indexInfo.position(offset))
 }
 
 func (s *segmentReader) Read(p []byte) (n int, err error) {
@@ -0,0 +1,349 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+)
+
+//snapshotMagic is the first 4 bytes of the snapshot stream
+const snapshotMagic uint32 = 0x53534e50
+
+type snapshotStream struct {
+	StreamID int64 `json:"stream_id"`
+	Begin    int64 `json:"begin"`
+	End      int64 `json:"end"`
+	//Records is the count of records of stream before End
+	Records     int64             `json:"records,omitempty"`
+	RecordIndex []recordIndexItem `json:"record_index,omitempty"`
+	//TimeIndex is the sparse index of append time,LastTS is the time of the last append
+	TimeIndex []timeIndexItem `json:"time_index,omitempty"`
+	LastTS    int64           `json:"last_ts,omitempty"`
+	//Extents are the ranges of data kept by compaction,the data of them follows
+	Extents []offsetExtent `json:"extents,omitempty"`
+}
+
+//size return the size of the data of stream in the snapshot
+func (stream snapshotStream) size() int64 {
+	return offsetInfo{Begin: stream.Begin, End: stream.End, Extents: stream.Extents}.size()
+}
+
+//snapshotHeader describe the streams in the snapshot,
+//the data of streams follow it in the same order
+type snapshotHeader struct {
+	Version     Version          `json:"version"`
+	LastEntryID int64            `json:"last_entry_id"`
+	Streams     []snapshotStream `json:"streams"`
+	//Producers are the producers state after the entry of LastEntryID
+	Producers map[int64]producerState `json:"producers,omitempty"`
+	//Epochs are the fencing epochs of streams
+	Epochs map[int64]int64 `json:"epochs,omitempty"`
+	//Modes are the append modes of streams not raw
+	Modes map[int64]byte `json:"modes,omitempty"`
+	//Consumers are the offsets of consumers by name and StreamID
+	Consumers map[string]map[int64]int64 `json:"consumers,omitempty"`
+	//Catalog are the streams by name,LastStreamID is the last ID allocated
+	Catalog      map[string]StreamMeta `json:"catalog,omitempty"`
+	LastStreamID int64                 `json:"last_stream_id,omitempty"`
+}
+
+//WriteSnapshot write a consistent image of all the streams to w,
+//the streams are cut at one Version.
+//the format is magic,header length,header,data of streams,crc of data
+func (sstore *SStore) WriteSnapshot(w io.Writer) error {
+	_, err := sstore.writeSnapshot(w)
+	return err
+}
+
+//writeSnapshot write the snapshot and return the header of it
+func (sstore *SStore) writeSnapshot(w io.Writer) (*snapshotHeader, error) {
+	//GC must not delete the segments being read
+	sstore.gcLocker.Lock()
+	defer sstore.gcLocker.Unlock()
+
+	var header snapshotHeader
+	var ends map[int64]int64
+	var records map[int64]int64
+	sstore.committer.barrier(func() {
+		ends, header.Version = sstore.endMap.CloneMap()
+		records, _ = sstore.recordMap.CloneMap()
+		header.LastEntryID = atomic.LoadInt64(&sstore.committer.lastEntryID)
+		header.Producers = sstore.committer.producers.clone()
+		header.Epochs = sstore.committer.epochs.clone()
+		header.Modes = sstore.committer.modes.clone()
+		header.Consumers = sstore.committer.consumers.clone()
+	})
+	header.Catalog, header.LastStreamID = sstore.files.getCatalog()
+	for streamID, end := range ends {
+		stream := snapshotStream{
+			StreamID: streamID,
+			Begin:    end,
+			End:      end,
+			Records:  records[streamID],
+		}
+		if offsetIndex := sstore.indexTable.get(streamID); offsetIndex != nil {
+			if offset, ok := offsetIndex.begin(); ok {
+				stream.Begin = offset
+			}
+			stream.Extents = offsetIndex.extents(stream.Begin, end)
+			//the records appended after the cut are not in the snapshot
+			for _, item := range offsetIndex.recordIndex() {
+				if item.Offset >= stream.Begin && item.Offset < end {
+					stream.RecordIndex = append(stream.RecordIndex, item)
+				}
+			}
+			for _, chunk := range offsetIndex.timeIndex() {
+				for _, item := range chunk.items {
+					if item.Offset >= stream.Begin && item.Offset < end {
+						stream.TimeIndex = append(stream.TimeIndex, item)
+					}
+				}
+				if chunk.lastTS > stream.LastTS {
+					stream.LastTS = chunk.lastTS
+				}
+			}
+		}
+		header.Streams = append(header.Streams, stream)
+	}
+	sort.Slice(header.Streams, func(i, j int) bool {
+		return header.Streams[i].StreamID < header.Streams[j].StreamID
+	})
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	writer := bufio.NewWriterSize(w, 1024*1024)
+	if err := binary.Write(writer, binary.BigEndian, snapshotMagic); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := binary.Write(writer, binary.BigEndian, uint32(len(data))); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	hash := crc32.NewIEEE()
+	for _, stream := range header.Streams {
+		if stream.Begin == stream.End {
+			continue
+		}
+		reader, err := sstore.Reader(stream.StreamID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := reader.Seek(stream.Begin, io.SeekStart); err != nil {
+			return nil, err
+		}
+		//the reader skips the gaps deleted by compaction
+		if _, err := io.CopyN(io.MultiWriter(writer, hash), reader, stream.size()); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	if err := binary.Write(writer, binary.BigEndian, hash.Sum32()); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &header, nil
+}
+
+//RestoreSnapshot replace the streams of the store with the snapshot
+//written by WriteSnapshot.the snapshot is checked before the local state
+//is replaced,the Readers created before are invalid after it
+func (sstore *SStore) RestoreSnapshot(r io.Reader) error {
+	if sstore.options.ReadOnly {
+		return ErrReadOnly
+	}
+	sstore.gcLocker.Lock()
+	defer sstore.gcLocker.Unlock()
+	if sstore.broken != nil {
+		return sstore.broken
+	}
+
+	filename := sstore.files.getNextSegment()
+	header, err := readSnapshot(r, filename)
+	if err != nil {
+		_ = os.Remove(filename)
+		return err
+	}
+	//the segment keeps LastEntryID even if the snapshot has no stream
+	var segments []string
+	if header.LastEntryID > 0 {
+		segments = append(segments, filepath.Base(filename))
+	} else if err := os.Remove(filename); err != nil {
+		return errors.WithStack(err)
+	}
+
+	sstore.restoreLocker.Lock()
+	defer sstore.restoreLocker.Unlock()
+	sstore.wWriter.close()
+	sstore.files.close()
+
+	//the new manifest journal takes the place of the old ones,
+	//the old segments and journals are deleted by reload.
+	//the store is reopened with the old manifest if writing it fails
+	writeErr := sstore.createRestoreManifest(segments, header)
+	if err := sstore.reopen(); err != nil {
+		//the writer is closed,the writes fail instead of waiting for it
+		sstore.broken = errors.WithMessage(ErrBroken, err.Error())
+		return err
+	}
+	return writeErr
+}
+
+//createRestoreManifest write the manifest of the segments,the catalog of
+//the snapshot and a new empty journal
+func (sstore *SStore) createRestoreManifest(segments []string, header *snapshotHeader) error {
+	walFile := sstore.files.getNextWal()
+	journal, err := openJournal(walFile)
+	if err != nil {
+		return err
+	}
+	if err := journal.Close(); err != nil {
+		return err
+	}
+	return writeManifestSnapshot(sstore.options.ManifestDir, sstore.files.filesIndex+1, &manifest{
+		Segments:     segments,
+		Journals:     []string{filepath.Base(walFile)},
+		Catalog:      header.Catalog,
+		LastStreamID: header.LastStreamID,
+	})
+}
+
+//reopenReload is the reload of reopen,it is replaced to fail the reopen in tests
+var reopenReload = reload
+
+//reopen reload the store from the manifest after the writer closed
+func (sstore *SStore) reopen() error {
+	for _, segment := range sstore.segments {
+		if err := segment.close(); err != nil {
+			return err
+		}
+	}
+	sstore.segments = make(map[string]*segment)
+	sstore.indexTable.reset()
+	sstore.endMap.reset()
+	sstore.recordMap.reset()
+	sstore.entryID = 0
+	if err := reopenReload(sstore); err != nil {
+		return err
+	}
+	ends, _ := sstore.endMap.CloneMap()
+	for streamID, end := range ends {
+		item := notifyPool.Get().(*notify)
+		item.streamID = streamID
+		item.end = end
+		sstore.endWatchers.notify(item)
+	}
+	return nil
+}
+
+//readSnapshot write the streams in snapshot to the segment file
+func readSnapshot(r io.Reader, filename string) (*snapshotHeader, error) {
+	reader := bufio.NewReaderSize(r, 1024*1024)
+	var magic uint32
+	if err := binary.Read(reader, binary.BigEndian, &magic); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if magic != snapshotMagic {
+		return nil, errors.Errorf("snapshot magic [%x] error", magic)
+	}
+	var length uint32
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var header snapshotHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	segment, err := createSegment(filename)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() {
+		_ = segment.close()
+	}()
+	writer := bufio.NewWriterSize(segment.f, 1024*1024)
+	hash := crc32.NewIEEE()
+	var offset int64
+	for _, stream := range header.Streams {
+		if stream.End < stream.Begin {
+			return nil, errors.Errorf("snapshot stream[%d] begin[%d] end[%d] error",
+				stream.StreamID, stream.Begin, stream.End)
+		}
+		streamHash := crc32.NewIEEE()
+		n, err := io.CopyN(io.MultiWriter(writer, hash, streamHash), reader, stream.size())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		segment.meta.OffSetInfos[stream.StreamID] = offsetInfo{
+			StreamID:    stream.StreamID,
+			Begin:       stream.Begin,
+			Offset:      offset,
+			End:         stream.End,
+			CRC:         streamHash.Sum32(),
+			Records:     stream.Records,
+			RecordIndex: stream.RecordIndex,
+			TimeIndex:   stream.TimeIndex,
+			LastTS:      stream.LastTS,
+			Extents:     stream.Extents,
+		}
+		offset += n
+	}
+	var crc uint32
+	if err := binary.Read(reader, binary.BigEndian, &crc); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if crc != hash.Sum32() {
+		return nil, errors.Errorf("snapshot crc[%d] error,expect [%d]", hash.Sum32(), crc)
+	}
+	segment.meta.Ver = header.Version
+	segment.meta.LastEntryID = header.LastEntryID
+	segment.meta.Producers = header.Producers
+	segment.meta.Epochs = header.Epochs
+	segment.meta.Modes = header.Modes
+	segment.meta.Consumers = header.Consumers
+	data, err = json.Marshal(segment.meta)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := binary.Write(writer, binary.BigEndian, int32(len(data))); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := segment.f.Sync(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &header, nil
+}
@@ -14,6 +14,7 @@
 package sstore
 
 import (
+	"context"
 	"github.com/pkg/errors"
 	"io"
 	"sync"
@@ -28,12 +29,25 @@ type SStore struct {
 	notifyPool  sync.Pool
 	segments    map[string]*segment
 	endMap      *int64LockMap
+	recordMap   *int64LockMap
 	committer   *committer
 	indexTable  *indexTable
 	endWatchers *endWatchers
 	wWriter     *wWriter
-	files       *manifest
-	isClose     int32
+	//commitNotify is shared by the committers across RestoreSnapshot
+	commitNotify *commitNotify
+	tailer       *journalTailer
+	files        *manifest
+	lock         *dirLock
+	gcLocker     sync.Mutex
+	//restoreLocker stop the appends while restoring snapshot
+	restoreLocker sync.RWMutex
+	//broken is set when RestoreSnapshot fails to reopen the store,
+	//the writes fail with it until the store is closed
+	broken error
+	//following is 1 when the entries come from the leader
+	following int32
+	isClose   int32
 }
 
 type Snapshot struct {
@@ -42,6 +56,7 @@ type Snapshot struct {
 }
 
 func Open(options Options) (*SStore, error) {
+	endMap := newInt64LockMap()
 	var sstore = &SStore{
 		options:    options,
 		entryQueue: newEntryQueue(options.EntryQueueCap),
@@ -50,13 +65,35 @@ func Open(options Options) (*SStore, error) {
 				return make(chan interface{}, 1)
 			},
 		},
-		segments:    make(map[string]*segment),
-		endMap:      newInt64LockMap(),
-		indexTable:  newIndexTable(),
-		endWatchers: newEndWatchers(),
+		segments:     make(map[string]*segment),
+		endMap:       endMap,
+		recordMap:    newInt64LockMap(),
+		indexTable:   newIndexTable(endMap),
+		endWatchers:  newEndWatchers(),
+		commitNotify: newCommitNotify(),
 	}
 
+	//a read only store runs next to the owner,it takes no lock
+	if options.ReadOnly == false {
+		lockPath := options.Path
+		if lockPath == "" {
+			lockPath = options.ManifestDir
+		}
+		if err := mkdir(lockPath); err != nil {
+			return nil, err
+		}
+		lock, err := lockDir(lockPath)
+		if err != nil {
+			return nil, err
+		}
+		sstore.lock = lock
+	}
+	sstore.endWatchers.start()
 	if err := reload(sstore); err != nil {
+		sstore.endWatchers.close()
+		if sstore.lock != nil {
+			_ = sstore.lock.unlock()
+		}
 		return nil, err
 	}
 	return sstore, nil
@@ -73,23 +110,34 @@ func (sstore *SStore) nextEntryID() int64 {
 //Append append the data to end of the stream
 //return offset to the data
 func (sstore *SStore) Append(streamID int64, data []byte, offset int64) (int64, error) {
-	notify := sstore.notifyPool.Get().(chan interface{})
 	var err error
 	var newOffset int64
-	sstore.AsyncAppend(streamID, data, offset, func(offset int64, e error) {
-		err = e
-		newOffset = offset
+	sstore.syncCall(func(done func()) {
+		sstore.AsyncAppend(streamID, data, offset, func(offset int64, e error) {
+			newOffset, err = offset, e
+			done()
+		})
+	})
+	return newOffset, err
+}
+
+//syncCall call the async call and wait until it calls done
+func (sstore *SStore) syncCall(call func(done func())) {
+	notify := sstore.notifyPool.Get().(chan interface{})
+	call(func() {
 		notify <- struct{}{}
 	})
 	<-notify
 	sstore.notifyPool.Put(notify)
-	return newOffset, err
 }
 
 //AsyncAppend async append the data to end of the stream
 func (sstore *SStore) AsyncAppend(streamID int64, data []byte, offset int64, cb func(offset int64, err error)) {
-	sstore.entryQueue.put(&entry{
-		ID:       sstore.nextEntryID(),
+	if err := sstore.checkAppend(streamID); err != nil {
+		cb(-1, err)
+		return
+	}
+	sstore.putEntry(&entry{
 		StreamID: streamID,
 		Offset:   offset,
 		data:     data,
@@ -97,6 +145,74 @@ func (sstore *SStore) AsyncAppend(streamID int64, data []byte, offset int64, cb
 	})
 }
 
+//AppendResult is the result of append
+type AppendResult struct {
+	//EntryID is the ID of entry of the append,it is increasing across streams
+	EntryID int64
+	//Begin,End are the offsets of the data written in the stream
+	Begin int64
+	End   int64
+}
+
+//AppendWithResult append the data to end of the stream,
+//return the entry ID and the offsets of the data
+func (sstore *SStore) AppendWithResult(streamID int64, data []byte, offset int64) (AppendResult, error) {
+	var err error
+	var result AppendResult
+	sstore.syncCall(func(done func()) {
+		sstore.AsyncAppendWithResult(streamID, data, offset, func(r AppendResult, e error) {
+			result, err = r, e
+			done()
+		})
+	})
+	return result, err
+}
+
+//AsyncAppendWithResult async append the data to end of the stream,
+//the cb gets the entry ID and the offsets of the data
+func (sstore *SStore) AsyncAppendWithResult(streamID int64, data []byte, offset int64,
+	cb func(result AppendResult, err error)) {
+	if err := sstore.checkAppend(streamID); err != nil {
+		cb(AppendResult{EntryID: -1, Begin: -1, End: -1}, err)
+		return
+	}
+	var e = &entry{
+		StreamID: streamID,
+		Offset:   offset,
+		data:     data,
+	}
+	e.cb = func(end int64, err error) {
+		if err != nil {
+			cb(AppendResult{EntryID: e.ID, Begin: -1, End: -1}, err)
+			return
+		}
+		cb(AppendResult{EntryID: e.ID, Begin: end - int64(len(data)), End: end}, nil)
+	}
+	sstore.putEntry(e)
+}
+
+//putEntry assign the ID to entry and put it to the queue of journal writer
+func (sstore *SStore) putEntry(e *entry) {
+	if sstore.options.ReadOnly {
+		e.cb(-1, ErrReadOnly)
+		return
+	}
+	if atomic.LoadInt32(&sstore.following) == 1 {
+		e.cb(-1, ErrFollower)
+		return
+	}
+	//RestoreSnapshot replaces the journal under the write lock
+	sstore.restoreLocker.RLock()
+	if err := sstore.broken; err != nil {
+		sstore.restoreLocker.RUnlock()
+		e.cb(-1, err)
+		return
+	}
+	e.ID = sstore.nextEntryID()
+	sstore.entryQueue.put(e)
+	sstore.restoreLocker.RUnlock()
+}
+
 //Reader create Reader of the stream
 func (sstore *SStore) Reader(streamID int64) (io.ReadSeeker, error) {
 	return sstore.indexTable.reader(streamID)
@@ -104,7 +220,32 @@ func (sstore *SStore) Reader(streamID int64) (io.ReadSeeker, error) {
 
 //Watcher create watcher of the stream
 func (sstore *SStore) Watcher(streamID int64) Watcher {
-	return sstore.endWatchers.newEndWatcher(streamID)
+	end, _ := sstore.endMap.get(streamID)
+	return sstore.endWatchers.newEndWatcher(streamID, end)
+}
+
+//WaitForEnd block until the end of stream reaches at least offset,
+//the ctx is cancelled or the store is closed
+func (sstore *SStore) WaitForEnd(ctx context.Context, streamID int64, offset int64) error {
+	if end, _ := sstore.endMap.get(streamID); end >= offset {
+		return nil
+	}
+	if atomic.LoadInt32(&sstore.isClose) == 1 {
+		return ErrClose
+	}
+	waiter := sstore.endWatchers.newEndWaiter(streamID, offset)
+	//the end may reach offset before the waiter created
+	if end, _ := sstore.endMap.get(streamID); end >= offset {
+		sstore.endWatchers.removeEndWaiter(waiter)
+		return nil
+	}
+	select {
+	case <-waiter.c:
+		return waiter.err
+	case <-ctx.Done():
+		sstore.endWatchers.removeEndWaiter(waiter)
+		return ctx.Err()
+	}
 }
 
 //size return the end of stream.
@@ -130,8 +271,25 @@ func (sstore *SStore) Exist(streamID int64) bool {
 	return ok
 }
 
+//Refresh pick up the segments and journal data written by the owner
+//of the read only store
+func (sstore *SStore) Refresh() error {
+	if sstore.options.ReadOnly == false {
+		return nil
+	}
+	return sstore.tailer.refresh()
+}
+
 //GC will delete useless journal manifest,segments
 func (sstore *SStore) GC() error {
+	if sstore.options.ReadOnly {
+		return ErrReadOnly
+	}
+	sstore.gcLocker.Lock()
+	defer sstore.gcLocker.Unlock()
+	if sstore.broken != nil {
+		return sstore.broken
+	}
 	if err := sstore.gcWal(); err != nil {
 		return err
 	}
@@ -146,9 +304,26 @@ func (sstore *SStore) Close() error {
 	if atomic.CompareAndSwapInt32(&sstore.isClose, 0, 1) == false {
 		return errors.New("repeated close")
 	}
-	sstore.wWriter.close()
-	sstore.files.close()
+	if sstore.options.ReadOnly {
+		if sstore.options.RefreshInterval > 0 {
+			sstore.tailer.close()
+		}
+		sstore.committer.close()
+		sstore.files.close()
+	} else {
+		sstore.restoreLocker.RLock()
+		broken := sstore.broken
+		sstore.restoreLocker.RUnlock()
+		//the writer and the manifest are closed by the failed restore
+		if broken == nil {
+			sstore.wWriter.close()
+			sstore.files.close()
+		}
+	}
 	sstore.endWatchers.close()
+	if sstore.lock != nil {
+		return sstore.lock.unlock()
+	}
 	return nil
 }
 
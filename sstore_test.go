@@ -1,12 +1,21 @@
 package sstore
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"github.com/pkg/errors"
 	"hash/crc32"
+	"io"
 	"io/ioutil"
+	"math"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -315,3 +324,2298 @@ func TestSStore_GC(t *testing.T) {
 		t.Fatalf("")
 	}
 }
+
+func TestSStore_ReadOnly(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	sstore, err := Open(DefaultOptions("data").WithMaxMStreamTableSize(MB))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	var data = []byte(strings.Repeat("hello world,", 10))
+	var appendData = func(count int) {
+		var wg sync.WaitGroup
+		for i := 0; i < count; i++ {
+			wg.Add(1)
+			sstore.AsyncAppend(int64(i%10), data, -1, func(offset int64, err error) {
+				if err != nil {
+					t.Errorf("%+v", err)
+				}
+				wg.Done()
+			})
+		}
+		wg.Wait()
+	}
+	var checkEnd = func(readOnly *SStore) {
+		for streamID := int64(0); streamID < 10; streamID++ {
+			end, _ := sstore.End(streamID)
+			if roEnd, _ := readOnly.End(streamID); roEnd != end {
+				t.Fatalf("stream[%d] end %d read only end %d", streamID, end, roEnd)
+			}
+			reader, err := readOnly.Reader(streamID)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			readAll, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if int64(len(readAll)) != end {
+				t.Fatalf("stream[%d] read %d end %d", streamID, len(readAll), end)
+			}
+		}
+	}
+	appendData(20000)
+
+	readOnly, err := Open(DefaultOptions("data").WithReadOnly(true))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer readOnly.Close()
+	checkEnd(readOnly)
+	if _, err := readOnly.Append(1, data, -1); err != ErrReadOnly {
+		t.Fatalf("%+v", err)
+	}
+	if err := readOnly.GC(); err != ErrReadOnly {
+		t.Fatalf("%+v", err)
+	}
+
+	//the owner flushes segments and rotates the journal
+	appendData(20000)
+	if err := readOnly.Refresh(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	checkEnd(readOnly)
+	if size := readOnly.committer.mutableMStreamMap.mSize; size >= MB {
+		t.Fatalf("mStreamTable size %d", size)
+	}
+}
+
+func TestSStore_Lock(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	sstore, err := Open(DefaultOptions("data"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := Open(DefaultOptions("data")); errors.Cause(err) != ErrLocked {
+		t.Fatalf("%+v", err)
+	} else if strings.Contains(err.Error(), strconv.Itoa(os.Getpid())) == false {
+		t.Fatalf("%s", err.Error())
+	}
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	sstore, err = Open(DefaultOptions("data"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func TestSStore_Checkpoint(t *testing.T) {
+	os.RemoveAll("data")
+	os.RemoveAll("checkpoint")
+	defer os.RemoveAll("data")
+	defer os.RemoveAll("checkpoint")
+	sstore, err := Open(DefaultOptions("data").WithMaxMStreamTableSize(MB))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	var data = []byte(strings.Repeat("hello world,", 10))
+	var wg sync.WaitGroup
+	var stop = make(chan interface{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := sstore.Append(int64(i%10), data, -1); err != nil {
+				t.Errorf("%+v", err)
+				return
+			}
+		}
+	}()
+	time.Sleep(time.Millisecond * 200)
+	if err := sstore.GC(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.Checkpoint("checkpoint"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	close(stop)
+	wg.Wait()
+
+	checkpoint, err := Open(DefaultOptions("checkpoint"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer checkpoint.Close()
+	for streamID := int64(0); streamID < 10; streamID++ {
+		end, _ := sstore.End(streamID)
+		checkpointEnd, ok := checkpoint.End(streamID)
+		if ok == false || checkpointEnd == 0 || checkpointEnd > end {
+			t.Fatalf("stream[%d] end %d checkpoint end %d", streamID, end, checkpointEnd)
+		}
+		reader, err := checkpoint.Reader(streamID)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		readAll, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if string(readAll) != strings.Repeat(string(data), int(checkpointEnd)/len(data)) {
+			t.Fatalf("stream[%d] checkpoint data error", streamID)
+		}
+	}
+	//the checkpoint is a store of its own
+	if _, err := checkpoint.Append(1, data, -1); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.Checkpoint("checkpoint"); err == nil {
+		t.Fatalf("checkpoint to dir not empty")
+	}
+}
+
+func TestSStore_Backup(t *testing.T) {
+	var dirs = []string{"data", "backup0", "backup1", "backup2", "restore", "restore2"}
+	for _, dir := range dirs {
+		os.RemoveAll(dir)
+	}
+	defer func() {
+		for _, dir := range dirs {
+			os.RemoveAll(dir)
+		}
+	}()
+	sstore, err := Open(DefaultOptions("data").
+		WithMaxMStreamTableSize(MB).
+		WithMaxWalSize(512 * KB))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var data = []byte(strings.Repeat("hello world,", 10))
+	var appendData = func(count int) {
+		var wg sync.WaitGroup
+		for i := 0; i < count; i++ {
+			wg.Add(1)
+			sstore.AsyncAppend(int64(i%10), data, -1, func(offset int64, err error) {
+				if err != nil {
+					t.Errorf("%+v", err)
+				}
+				wg.Done()
+			})
+		}
+		wg.Wait()
+	}
+	appendData(20000)
+	base, err := sstore.Backup("backup0", nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	appendData(20000)
+	if err := sstore.GC(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	incremental, err := sstore.Backup("backup1", base)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, file := range incremental.Files {
+		for _, segment := range base.Segments {
+			if file.Name == filepath.Join("segment", segment) {
+				t.Fatalf("%s in incremental backup", file.Name)
+			}
+		}
+	}
+	appendData(5000)
+	if _, err := sstore.Backup("backup2", incremental); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var ends = map[int64]int64{}
+	for streamID := int64(0); streamID < 10; streamID++ {
+		ends[streamID], _ = sstore.End(streamID)
+	}
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	//the store reopens after GC
+	sstore, err = Open(DefaultOptions("data"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if err := RestoreBackup("restore", "backup0", "backup2"); err == nil {
+		t.Fatalf("restore with a missing backup")
+	}
+	if err := RestoreBackup("restore", "backup0", "backup1", "backup2"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	restore, err := Open(DefaultOptions("restore"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for streamID := int64(0); streamID < 10; streamID++ {
+		if end, _ := restore.End(streamID); end != ends[streamID] {
+			t.Fatalf("stream[%d] end %d restore end %d", streamID, ends[streamID], end)
+		}
+	}
+	if err := restore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	//corrupt a segment of the base backup
+	segment := filepath.Join("backup0", "segment", base.Segments[0])
+	f, err := os.OpenFile(segment, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := f.WriteAt([]byte("HELLO"), 0); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	f.Close()
+	if err := RestoreBackup("restore2", "backup0", "backup1", "backup2"); err == nil ||
+		strings.Contains(err.Error(), "crc") == false {
+		t.Fatalf("restore corrupt segment %+v", err)
+	}
+}
+
+func TestSStore_Snapshot(t *testing.T) {
+	os.RemoveAll("data")
+	os.RemoveAll("data2")
+	defer os.RemoveAll("data")
+	defer os.RemoveAll("data2")
+	sstore, err := Open(DefaultOptions("data").WithMaxMStreamTableSize(MB))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	var data = []byte(strings.Repeat("hello world,", 10))
+	for i := 0; i < 20000; i++ {
+		if _, err := sstore.Append(int64(i%10), data, -1); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	var buffer bytes.Buffer
+	if err := sstore.WriteSnapshot(&buffer); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	follower, err := Open(DefaultOptions("data2"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := follower.Append(int64(i%20), []byte("stale"), -1); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	//a broken snapshot leaves the store untouched
+	broken := append([]byte{}, buffer.Bytes()...)
+	broken[len(broken)/2] ^= 0xff
+	if err := follower.RestoreSnapshot(bytes.NewReader(broken)); err == nil {
+		t.Fatalf("restore broken snapshot")
+	}
+	if end, _ := follower.End(15); end != 5*5 {
+		t.Fatalf("stream end %d error", end)
+	}
+	if err := follower.RestoreSnapshot(bytes.NewReader(buffer.Bytes())); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var check = func(follower *SStore) {
+		if follower.Exist(15) {
+			t.Fatalf("stream 15 exist after restore")
+		}
+		for streamID := int64(0); streamID < 10; streamID++ {
+			end, _ := sstore.End(streamID)
+			followerEnd, _ := follower.End(streamID)
+			if followerEnd != end+int64(len(data)) {
+				t.Fatalf("stream[%d] end %d follower end %d", streamID, end, followerEnd)
+			}
+			reader, err := follower.Reader(streamID)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			readAll, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if string(readAll) != strings.Repeat(string(data), 2001) {
+				t.Fatalf("stream[%d] data error", streamID)
+			}
+		}
+	}
+	for streamID := int64(0); streamID < 10; streamID++ {
+		if _, err := follower.Append(streamID, data, -1); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	check(follower)
+	if err := follower.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	follower, err = Open(DefaultOptions("data2"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer follower.Close()
+	check(follower)
+}
+
+func TestSStore_RestoreSnapshotReopenFailed(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	sstore, err := Open(DefaultOptions("data"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := sstore.Append(int64(i%10), []byte("hello world"), -1); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	var buffer bytes.Buffer
+	if err := sstore.WriteSnapshot(&buffer); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	reopenReload = func(*SStore) error {
+		return errors.New("reload failed")
+	}
+	err = sstore.RestoreSnapshot(bytes.NewReader(buffer.Bytes()))
+	reopenReload = reload
+	if err == nil {
+		t.Fatalf("restore with reload failed")
+	}
+	//the writes fail instead of hanging without the writer
+	if _, err := sstore.Append(1, []byte("hello world"), -1); errors.Cause(err) != ErrBroken {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.GC(); errors.Cause(err) != ErrBroken {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.RestoreSnapshot(bytes.NewReader(buffer.Bytes())); errors.Cause(err) != ErrBroken {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	//the manifest of the snapshot is written before the reopen
+	sstore, err = Open(DefaultOptions("data"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	if end, _ := sstore.End(1); end != 10*int64(len("hello world")) {
+		t.Fatalf("stream end %d", end)
+	}
+}
+
+func TestSStore_Replication(t *testing.T) {
+	os.RemoveAll("data")
+	os.RemoveAll("data2")
+	defer os.RemoveAll("data")
+	defer os.RemoveAll("data2")
+	leader, err := Open(DefaultOptions("data").
+		WithMaxMStreamTableSize(256 * KB).
+		WithMaxWalSize(256 * KB))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer leader.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	server, err := leader.ServeReplication(listener)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer server.Close()
+
+	var data = []byte(strings.Repeat("hello world,", 10))
+	var appendData = func(count int) {
+		for i := 0; i < count; i++ {
+			if _, err := leader.Append(int64(i%10), data, -1); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+	}
+	var waitFollower = func(follower *SStore) {
+		for start := time.Now(); ; time.Sleep(time.Millisecond * 10) {
+			var synced = true
+			for streamID := int64(0); streamID < 10; streamID++ {
+				end, _ := leader.End(streamID)
+				followerEnd, _ := follower.End(streamID)
+				if end != followerEnd {
+					synced = false
+				}
+			}
+			if synced {
+				break
+			}
+			if time.Since(start) > time.Second*10 {
+				t.Fatalf("wait follower timeout")
+			}
+		}
+		for streamID := int64(0); streamID < 10; streamID++ {
+			end, _ := leader.End(streamID)
+			reader, err := follower.Reader(streamID)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			readAll, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if string(readAll) != strings.Repeat(string(data), int(end)/len(data)) {
+				t.Fatalf("stream[%d] follower data error", streamID)
+			}
+		}
+	}
+
+	//ship the entries in journal
+	appendData(1000)
+	follower, err := Open(DefaultOptions("data2"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	replica, err := follower.Follow(server.Addr().String())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	waitFollower(follower)
+	appendData(1000)
+	waitFollower(follower)
+	if _, err := follower.Append(1, data, -1); err != ErrFollower {
+		t.Fatalf("append to follower %+v", err)
+	}
+	if err := replica.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	//the entries the follower wants are deleted,ship the snapshot
+	appendData(20000)
+	time.Sleep(time.Millisecond * 200)
+	if err := leader.GC(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if walFiles := leader.files.getWalFiles(); walFiles[0] == "1.log" {
+		t.Fatalf("journal not deleted %+v", walFiles)
+	}
+	replica, err = follower.Follow(server.Addr().String())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	waitFollower(follower)
+	appendData(1000)
+	waitFollower(follower)
+	if err := replica.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := follower.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	follower, err = Open(DefaultOptions("data2"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer follower.Close()
+	waitFollower(follower)
+}
+
+func TestSStore_Subscribe(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	var options = DefaultOptions("data").
+		WithMaxMStreamTableSize(256 * KB).
+		WithMaxWalSize(256 * KB)
+	sstore, err := Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var events []ChangeEvent
+	var appendData = func(count int) {
+		for i := 0; i < count; i++ {
+			data := []byte(fmt.Sprintf("%d-%s", i, strings.Repeat("hello world,", 10)))
+			streamID := int64(i % 3)
+			end, err := sstore.Append(streamID, data, -1)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			events = append(events, ChangeEvent{StreamID: streamID, Offset: end - int64(len(data)), Data: data})
+			//the failed appends are skipped
+			if _, err := sstore.Append(streamID, data, 1); err != ErrOffset {
+				t.Fatalf("append with offset error %+v", err)
+			}
+		}
+	}
+	var checkEvents = func(sstore *SStore) {
+		sub, err := sstore.Subscribe(1)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer sub.Close()
+		var last int64
+		for _, expect := range events {
+			event, err := sub.Next()
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if event.EntryID <= last || event.StreamID != expect.StreamID ||
+				event.Offset != expect.Offset || string(event.Data) != string(expect.Data) {
+				t.Fatalf("event %+v error,expect %+v", event, expect)
+			}
+			last = event.EntryID
+		}
+	}
+	appendData(3000)
+	checkEvents(sstore)
+
+	//wait the hot tail
+	sub, err := sstore.Subscribe(atomic.LoadInt64(&sstore.entryID) + 1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var eventCh = make(chan *ChangeEvent, 1)
+	go func() {
+		event, err := sub.Next()
+		if err != nil {
+			t.Errorf("%+v", err)
+		}
+		eventCh <- event
+	}()
+	time.Sleep(time.Millisecond * 10)
+	if _, err := sstore.Append(100, []byte("hello"), -1); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if event := <-eventCh; event == nil || event.StreamID != 100 || string(event.Data) != "hello" {
+		t.Fatalf("event %+v error", event)
+	}
+	_ = sub.Close()
+	if _, err := sub.Next(); err != ErrClose {
+		t.Fatalf("next after close %+v", err)
+	}
+	events = append(events, ChangeEvent{StreamID: 100, Data: []byte("hello")})
+
+	//the entries flushed are read from the journals after reopen
+	time.Sleep(time.Millisecond * 100)
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	sstore, err = Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	checkEvents(sstore)
+
+	appendData(3000)
+	time.Sleep(time.Millisecond * 100)
+	if err := sstore.GC(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	sstore, err = Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	sub, err = sstore.Subscribe(1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := sub.Next(); errors.Cause(err) != ErrEntryGC {
+		t.Fatalf("subscribe entries deleted %+v", err)
+	}
+}
+
+func TestSStore_AppendWithResult(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	sstore, err := Open(DefaultOptions("data"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	var data = []byte("hello world")
+	var last AppendResult
+	for i := 0; i < 100; i++ {
+		streamID := int64(i % 3)
+		end, _ := sstore.End(streamID)
+		result, err := sstore.AppendWithResult(streamID, data, -1)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if (i > 0 && result.EntryID != last.EntryID+1) ||
+			result.Begin != end || result.End != end+int64(len(data)) {
+			t.Fatalf("result %+v error,last %+v end %d", result, last, end)
+		}
+		last = result
+	}
+	result, err := sstore.AppendWithResult(1, data, 1)
+	if err != ErrOffset || result.EntryID != last.EntryID+1 || result.End != -1 {
+		t.Fatalf("result %+v err %+v", result, err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	sstore.AsyncAppendWithResult(1, data, -1, func(result AppendResult, err error) {
+		defer wg.Done()
+		if err != nil {
+			t.Errorf("%+v", err)
+		}
+		if result.EntryID != last.EntryID+2 {
+			t.Errorf("result %+v error", result)
+		}
+	})
+	wg.Wait()
+}
+
+func TestSStore_AppendBatch(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	sstore, err := Open(DefaultOptions("data"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var data = []byte("hello world")
+	for i := 0; i < 100; i++ {
+		results, err := sstore.AppendBatch([]AppendOp{
+			{StreamID: 1, Data: data, Offset: -1},
+			{StreamID: 2, Data: data[:5], Offset: int64(i * 5)},
+			{StreamID: 1, Data: data, Offset: int64(i*2+1) * int64(len(data))},
+		})
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if len(results) != 3 || results[0].EntryID != results[2].EntryID ||
+			results[2].Begin != results[0].End || results[1].End != int64(i+1)*5 {
+			t.Fatalf("results %+v error", results)
+		}
+	}
+	//none of the ops is appended if any offset is wrong
+	if _, err := sstore.AppendBatch([]AppendOp{
+		{StreamID: 1, Data: data, Offset: -1},
+		{StreamID: 3, Data: data, Offset: -1},
+		{StreamID: 2, Data: data, Offset: 1},
+	}); err != ErrOffset {
+		t.Fatalf("append batch with offset error %+v", err)
+	}
+	var check = func(sstore *SStore) {
+		if end, _ := sstore.End(1); end != 200*int64(len(data)) {
+			t.Fatalf("stream 1 end %d error", end)
+		}
+		if end, _ := sstore.End(2); end != 500 {
+			t.Fatalf("stream 2 end %d error", end)
+		}
+		if sstore.Exist(3) {
+			t.Fatalf("stream 3 exist")
+		}
+	}
+	check(sstore)
+	sub, err := sstore.Subscribe(1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for i := 0; i < 3; i++ {
+		event, err := sub.Next()
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if event.EntryID != 1 || event.StreamID != []int64{1, 2, 1}[i] {
+			t.Fatalf("event %+v error", event)
+		}
+	}
+	_ = sub.Close()
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	sstore, err = Open(DefaultOptions("data"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	check(sstore)
+}
+
+func TestSStore_AppendIdempotent(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	options := DefaultOptions("data")
+	options.MaxMStreamTableSize = 1024
+	sstore, err := Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var data = []byte("hello world")
+	var results []AppendResult
+	for seq := int64(1); seq <= 200; seq++ {
+		result, err := sstore.AppendIdempotent(1, seq, 1, data, -1)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if result.End != seq*int64(len(data)) {
+			t.Fatalf("result %+v error", result)
+		}
+		results = append(results, result)
+		//the retry is acknowledged with the original result
+		retry, err := sstore.AppendIdempotent(1, seq, 1, data, -1)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if retry != result {
+			t.Fatalf("retry result %+v expect %+v", retry, result)
+		}
+	}
+	if _, err := sstore.AppendIdempotent(1, 1, 1, data, -1); err != ErrDuplicate {
+		t.Fatalf("append out of window %+v", err)
+	}
+	if _, err := sstore.AppendIdempotent(1, 202, 1, data, -1); err != ErrSequence {
+		t.Fatalf("append out of sequence %+v", err)
+	}
+	if _, err := sstore.AppendIdempotent(2, 2, 1, data, -1); err != ErrSequence {
+		t.Fatalf("append out of sequence %+v", err)
+	}
+	var check = func(sstore *SStore) {
+		if end, _ := sstore.End(1); end != 200*int64(len(data)) {
+			t.Fatalf("stream 1 end %d error", end)
+		}
+		if seq, ok := sstore.ProducerSeq(1); ok == false || seq != 200 {
+			t.Fatalf("producer seq %d error", seq)
+		}
+		retry, err := sstore.AppendIdempotent(1, 200, 1, data, -1)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if retry != results[199] {
+			t.Fatalf("retry result %+v expect %+v", retry, results[199])
+		}
+	}
+	check(sstore)
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	//the producers state is recovered from the segments and journals
+	sstore, err = Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	check(sstore)
+	if len(sstore.files.getSegmentFiles()) == 0 {
+		t.Fatalf("no segment flushed")
+	}
+}
+
+func TestSStore_Fencing(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	sstore, err := Open(DefaultOptions("data"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var data = []byte("hello world")
+	if err := sstore.SetEpoch(1, 1); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := sstore.AppendWithEpoch(1, 1, data, -1); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	//the new owner fences the old one
+	if err := sstore.SetEpoch(1, 2); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.SetEpoch(1, 1); err != ErrFenced {
+		t.Fatalf("set older epoch %+v", err)
+	}
+	//the stale owner is rejected even with the right offset
+	if _, err := sstore.AppendWithEpoch(1, 1, data, int64(len(data))); err != ErrFenced {
+		t.Fatalf("append with older epoch %+v", err)
+	}
+	if _, err := sstore.AppendBatchWithEpoch(1, []AppendOp{
+		{StreamID: 2, Data: data, Offset: -1},
+		{StreamID: 1, Data: data, Offset: -1},
+	}); err != ErrFenced {
+		t.Fatalf("append batch with older epoch %+v", err)
+	}
+	if result, err := sstore.AppendWithEpoch(2, 1, data, -1); err != nil {
+		t.Fatalf("%+v", err)
+	} else if result.Begin != int64(len(data)) {
+		t.Fatalf("result %+v error", result)
+	}
+	var check = func(sstore *SStore) {
+		if end, _ := sstore.End(1); end != 2*int64(len(data)) {
+			t.Fatalf("stream 1 end %d error", end)
+		}
+		if sstore.Exist(2) {
+			t.Fatalf("stream 2 exist")
+		}
+		if epoch := sstore.Epoch(1); epoch != 2 {
+			t.Fatalf("epoch %d error", epoch)
+		}
+		if _, err := sstore.AppendWithEpoch(1, 1, data, -1); err != ErrFenced {
+			t.Fatalf("append with older epoch %+v", err)
+		}
+		//the writer without epoch is fenced too
+		if _, err := sstore.Append(1, data, -1); err != ErrFenced {
+			t.Fatalf("append without epoch %+v", err)
+		}
+		if _, err := sstore.AppendBatch([]AppendOp{
+			{StreamID: 2, Data: data, Offset: -1},
+			{StreamID: 1, Data: data, Offset: -1},
+		}); err != ErrFenced {
+			t.Fatalf("append batch without epoch %+v", err)
+		}
+		if _, err := sstore.AppendIdempotent(1, 1, 1, data, -1); err != ErrFenced {
+			t.Fatalf("append idempotent without epoch %+v", err)
+		}
+	}
+	check(sstore)
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	sstore, err = Open(DefaultOptions("data"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	check(sstore)
+	//the epochs are kept by the snapshot
+	var buffer bytes.Buffer
+	if err := sstore.WriteSnapshot(&buffer); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.RestoreSnapshot(&buffer); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	check(sstore)
+}
+
+func TestSStore_RecordReader(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	//the records straddle the pages and the segments
+	options := DefaultOptions("data").WithBlockSize(100)
+	options.MaxMStreamTableSize = 1000
+	sstore, err := Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	var records []AppendResult
+	for i := 0; i < 500; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, i%150+1)
+		result, err := sstore.AppendRecord(1, data, -1)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		records = append(records, result)
+	}
+	if len(sstore.files.getSegmentFiles()) == 0 {
+		t.Fatalf("no segment flushed")
+	}
+	reader, err := sstore.RecordReader(1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for i, result := range records {
+		record, err := reader.Next()
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if record.Begin != result.Begin || record.End != result.End ||
+			bytes.Equal(record.Data, bytes.Repeat([]byte{byte(i)}, i%150+1)) == false {
+			t.Fatalf("record %d [%d,%d) error", i, record.Begin, record.End)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("read end of stream %+v", err)
+	}
+	//the record appended later is read
+	if _, err := sstore.AppendRecord(1, []byte("hello"), -1); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if record, err := reader.Next(); err != nil || string(record.Data) != "hello" {
+		t.Fatalf("read new record %+v", err)
+	}
+	//seek to the next boundary from any byte offset
+	for i := 0; i < len(records)-1; i += 7 {
+		for _, offset := range []int64{records[i].Begin, records[i].Begin + 1, records[i].End - 1} {
+			if err := reader.SeekRecord(offset); err != nil {
+				t.Fatalf("%+v", err)
+			}
+			expect := records[i+1].Begin
+			if offset == records[i].Begin {
+				expect = records[i].Begin
+			}
+			if reader.Offset() != expect {
+				t.Fatalf("seek %d to %d expect %d", offset, reader.Offset(), expect)
+			}
+			if record, err := reader.Next(); err != nil || record.Begin != expect {
+				t.Fatalf("read after seek %+v", err)
+			}
+		}
+	}
+	//the frame nested in the payload is not the boundary
+	nested, err := sstore.AppendRecord(1, encodeRecord([]byte("nested")), -1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := sstore.AppendRecord(1, []byte("after nested"), -1); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := reader.SeekRecord(nested.Begin + recordHeaderSize); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if record, err := reader.Next(); err != nil || string(record.Data) != "after nested" {
+		t.Fatalf("read after nested frame %+v", err)
+	}
+}
+
+func TestSStore_RecordIndex(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	options := DefaultOptions("data").WithBlockSize(100)
+	options.MaxMStreamTableSize = 4000
+	sstore, err := Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var records []AppendResult
+	for i := 0; i < 1000; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, i%20+1)
+		result, err := sstore.AppendRecord(1, data, -1)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		records = append(records, result)
+		//the raw appends are not mixed into the records
+		if _, err := sstore.Append(1, encodeRecord(data), -1); errors.Cause(err) != ErrStreamMode {
+			t.Fatalf("append raw data to record stream %+v", err)
+		}
+		if _, err := sstore.Append(2, encodeRecord(data), -1); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if i%100 == 0 {
+			if _, err := sstore.Append(2, data, -1); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+	}
+	var check = func(sstore *SStore) {
+		//the raw stream has no record index
+		if _, err := sstore.OffsetOfRecord(2, 0); errors.Cause(err) != ErrStreamMode {
+			t.Fatalf("offset of record of raw stream %+v", err)
+		}
+		if _, _, err := sstore.RecordAt(2, 0); errors.Cause(err) != ErrStreamMode {
+			t.Fatalf("record at offset of raw stream %+v", err)
+		}
+		for n, result := range records {
+			offset, err := sstore.OffsetOfRecord(1, int64(n))
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if offset != result.Begin {
+				t.Fatalf("record %d offset %d expect %d", n, offset, result.Begin)
+			}
+			for _, offset := range []int64{result.Begin, result.End - 1} {
+				seq, begin, err := sstore.RecordAt(1, offset)
+				if err != nil {
+					t.Fatalf("%+v", err)
+				}
+				if seq != int64(n) || begin != result.Begin {
+					t.Fatalf("record at %d is %d %d expect %d %d", offset, seq, begin, n, result.Begin)
+				}
+			}
+		}
+		end, _ := sstore.End(1)
+		if offset, err := sstore.OffsetOfRecord(1, int64(len(records))); err != nil || offset != end {
+			t.Fatalf("offset of end %d %+v", offset, err)
+		}
+		if _, err := sstore.OffsetOfRecord(1, int64(len(records))+1); errors.Cause(err) != ErrOffset {
+			t.Fatalf("offset of record out of range %+v", err)
+		}
+	}
+	check(sstore)
+	if len(sstore.files.getSegmentFiles()) == 0 {
+		t.Fatalf("no segment flushed")
+	}
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	//the index is rebuilt from the segments and journals
+	sstore, err = Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	check(sstore)
+	var buffer bytes.Buffer
+	if err := sstore.WriteSnapshot(&buffer); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.RestoreSnapshot(&buffer); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	check(sstore)
+}
+
+func TestSStore_OffsetForTime(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	var now = time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	var tick int64
+	var clock = func() time.Time {
+		return now.Add(time.Duration(atomic.LoadInt64(&tick)) * time.Second)
+	}
+	options := DefaultOptions("data").WithClock(clock)
+	options.MaxMStreamTableSize = 1000
+	sstore, err := Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var data = []byte("hello world")
+	var offsets []int64
+	for i := 0; i < 300; i++ {
+		atomic.StoreInt64(&tick, int64(i))
+		result, err := sstore.AppendWithResult(1, data, -1)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		offsets = append(offsets, result.Begin)
+	}
+	var check = func(sstore *SStore) {
+		for i, offset := range offsets {
+			result, err := sstore.OffsetForTime(1, now.Add(time.Duration(i)*time.Second))
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if result != offset {
+				t.Fatalf("offset for %d second %d expect %d", i, result, offset)
+			}
+		}
+		if offset, _ := sstore.OffsetForTime(1, now.Add(-time.Hour)); offset != 0 {
+			t.Fatalf("offset before all %d", offset)
+		}
+		end, _ := sstore.End(1)
+		if offset, _ := sstore.OffsetForTime(1, now.Add(time.Hour)); offset != end {
+			t.Fatalf("offset after all %d expect %d", offset, end)
+		}
+		reader, err := sstore.TimeReader(1)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if _, err := reader.SeekTime(now.Add(299 * time.Second)); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if remain, err := ioutil.ReadAll(reader); err != nil || bytes.Equal(remain, data) == false {
+			t.Fatalf("read after seek time %s %+v", remain, err)
+		}
+	}
+	check(sstore)
+	if len(sstore.files.getSegmentFiles()) == 0 {
+		t.Fatalf("no segment flushed")
+	}
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	//the timestamps are replayed from the journals
+	sstore, err = Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	check(sstore)
+}
+
+func TestSStore_FollowReader(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	sstore, err := Open(DefaultOptions("data"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	//the stream is created after the reader
+	reader, err := sstore.FollowReader(context.Background(), 1, 0)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var data = []byte("hello world")
+	go func() {
+		for i := 0; i < 100; i++ {
+			if _, err := sstore.Append(1, data, -1); err != nil {
+				t.Errorf("%+v", err)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	buf := make([]byte, 100*len(data))
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if bytes.Equal(buf, bytes.Repeat(data, 100)) == false {
+		t.Fatalf("data error")
+	}
+	//Close unblocks Read
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		_ = reader.Close()
+	}()
+	if _, err := reader.Read(buf); err != ErrClose {
+		t.Fatalf("read after close %+v", err)
+	}
+	//the cancelled ctx unblocks Read
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	reader, err = sstore.FollowReader(ctx, 1, int64(50*len(data)))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer reader.Close()
+	remain, err := ioutil.ReadAll(reader)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("read with ctx cancelled %+v", err)
+	}
+	if bytes.Equal(remain, bytes.Repeat(data, 50)) == false {
+		t.Fatalf("data error")
+	}
+}
+
+func TestSStore_WaitForEnd(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	sstore, err := Open(DefaultOptions("data"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var data = []byte("hello world")
+	if _, err := sstore.Append(1, data, -1); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.WaitForEnd(context.Background(), 1, int64(len(data))); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var wg sync.WaitGroup
+	var count int32
+	for i := 1; i <= 1000; i++ {
+		wg.Add(1)
+		go func(offset int64) {
+			defer wg.Done()
+			if err := sstore.WaitForEnd(context.Background(), 2, offset); err != nil {
+				t.Errorf("%+v", err)
+				return
+			}
+			if end, _ := sstore.End(2); end < offset {
+				t.Errorf("end %d offset %d", end, offset)
+			}
+			atomic.AddInt32(&count, 1)
+		}(int64(i * len(data)))
+	}
+	for i := 0; i < 1000; i++ {
+		if _, err := sstore.Append(2, data, -1); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	wg.Wait()
+	if count != 1000 {
+		t.Fatalf("count %d", count)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if err := sstore.WaitForEnd(ctx, 2, 1001*int64(len(data))); err != context.DeadlineExceeded {
+		t.Fatalf("wait with ctx cancelled %+v", err)
+	}
+	//the waiters are woken up by Close
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		_ = sstore.Close()
+	}()
+	if err := sstore.WaitForEnd(context.Background(), 3, 1); err != ErrClose {
+		t.Fatalf("wait after close %+v", err)
+	}
+	//the waiter registered after the check of close fails too
+	waiter := sstore.endWatchers.newEndWaiter(3, 1)
+	select {
+	case <-waiter.c:
+		if waiter.err != ErrClose {
+			t.Fatalf("waiter after close %+v", waiter.err)
+		}
+	default:
+		t.Fatalf("waiter after close blocks")
+	}
+}
+
+func TestSStore_MultiWatcher(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	sstore, err := Open(DefaultOptions("data"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	setWatcher := sstore.MultiWatcher(StreamSet(1, 3))
+	defer setWatcher.Close()
+	rangeWatcher := sstore.MultiWatcher(StreamRange(2, 4))
+	defer rangeWatcher.Close()
+	var l sync.Mutex
+	var ends = map[int64]int64{}
+	allWatcher := sstore.WatchFunc(AllStreams(), func(update StreamEnd) {
+		l.Lock()
+		defer l.Unlock()
+		if update.End < ends[update.StreamID] {
+			t.Errorf("update %+v go back", update)
+		}
+		ends[update.StreamID] = update.End
+	})
+	var data = []byte("hello world")
+	for i := 0; i < 100; i++ {
+		for streamID := int64(1); streamID <= 4; streamID++ {
+			if _, err := sstore.Append(streamID, data, -1); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+	}
+	var last = int64(100 * len(data))
+	var wait = func(watcher *MultiWatcher, streamIDs ...int64) {
+		var reached = map[int64]bool{}
+		for len(reached) < len(streamIDs) {
+			select {
+			case update := <-watcher.Watch():
+				var watched bool
+				for _, streamID := range streamIDs {
+					watched = watched || streamID == update.StreamID
+				}
+				if watched == false {
+					t.Fatalf("update %+v not watched", update)
+				}
+				if update.End == last {
+					reached[update.StreamID] = true
+				}
+			case <-time.After(time.Second * 5):
+				t.Fatalf("wait update timeout")
+			}
+		}
+	}
+	wait(setWatcher, 1, 3)
+	wait(rangeWatcher, 2, 3)
+	for {
+		l.Lock()
+		count := len(ends)
+		for _, end := range ends {
+			if end != last {
+				count = 0
+			}
+		}
+		l.Unlock()
+		if count == 4 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	allWatcher.Close()
+	//the chan is closed after the watcher closed
+	setWatcher.Close()
+	for range setWatcher.Watch() {
+	}
+	//the watchers still open are closed with the store
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for range rangeWatcher.Watch() {
+	}
+	for range sstore.MultiWatcher(AllStreams()).Watch() {
+	}
+}
+
+type testEventListener struct {
+	BaseEventListener
+	l              sync.Mutex
+	flushes        []FlushEvent
+	segmentDeletes []SegmentDeleteEvent
+	walRotates     []WalRotateEvent
+	walDeletes     []WalDeleteEvent
+	beginMoves     []BeginMoveEvent
+	recoveries     []RecoveryEvent
+}
+
+func (listener *testEventListener) OnFlush(event FlushEvent) {
+	listener.l.Lock()
+	defer listener.l.Unlock()
+	listener.flushes = append(listener.flushes, event)
+}
+
+func (listener *testEventListener) OnSegmentDelete(event SegmentDeleteEvent) {
+	listener.l.Lock()
+	defer listener.l.Unlock()
+	listener.segmentDeletes = append(listener.segmentDeletes, event)
+}
+
+func (listener *testEventListener) OnWalRotate(event WalRotateEvent) {
+	listener.l.Lock()
+	defer listener.l.Unlock()
+	listener.walRotates = append(listener.walRotates, event)
+}
+
+func (listener *testEventListener) OnWalDelete(event WalDeleteEvent) {
+	listener.l.Lock()
+	defer listener.l.Unlock()
+	listener.walDeletes = append(listener.walDeletes, event)
+}
+
+func (listener *testEventListener) OnBeginMove(event BeginMoveEvent) {
+	listener.l.Lock()
+	defer listener.l.Unlock()
+	listener.beginMoves = append(listener.beginMoves, event)
+}
+
+func (listener *testEventListener) OnRecovery(event RecoveryEvent) {
+	listener.l.Lock()
+	defer listener.l.Unlock()
+	listener.recoveries = append(listener.recoveries, event)
+}
+
+func TestSStore_EventListener(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	listener := &testEventListener{}
+	options := DefaultOptions("data").
+		WithMaxMStreamTableSize(MB).
+		WithMaxWalSize(MB).
+		WithMaxSegmentCount(2).
+		WithEventListener(listener)
+	sstore, err := Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(listener.recoveries) != 1 || listener.recoveries[0].Entries != 0 {
+		t.Fatalf("recoveries %+v", listener.recoveries)
+	}
+	var data = []byte(strings.Repeat("hello world,", 100))
+	var wg sync.WaitGroup
+	for i := 0; i < 10000; i++ {
+		wg.Add(1)
+		sstore.AsyncAppend(int64(i%2), data, -1, func(offset int64, err error) {
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	if err := sstore.GC(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	listener.l.Lock()
+	if len(listener.flushes) == 0 || len(listener.walRotates) == 0 {
+		t.Fatalf("flushes %d walRotates %d", len(listener.flushes), len(listener.walRotates))
+	}
+	for _, event := range listener.flushes {
+		if event.Size == 0 || event.LastEntryID == 0 || len(event.Streams) != 2 {
+			t.Fatalf("flush %+v", event)
+		}
+	}
+	for _, event := range listener.walRotates {
+		if event.Filename == event.NewFilename || event.Size < MB {
+			t.Fatalf("walRotate %+v", event)
+		}
+	}
+	if len(listener.walDeletes) == 0 || len(listener.segmentDeletes) == 0 {
+		t.Fatalf("walDeletes %d segmentDeletes %d",
+			len(listener.walDeletes), len(listener.segmentDeletes))
+	}
+	var begins = map[int64]int64{}
+	for _, event := range listener.beginMoves {
+		if event.OldBegin != begins[event.StreamID] || event.Begin <= event.OldBegin {
+			t.Fatalf("beginMove %+v", event)
+		}
+		begins[event.StreamID] = event.Begin
+	}
+	for streamID := int64(0); streamID < 2; streamID++ {
+		begin, _ := sstore.Begin(streamID)
+		if begin == 0 || begins[streamID] != begin {
+			t.Fatalf("stream[%d] begin %d %d", streamID, begin, begins[streamID])
+		}
+	}
+	listener.l.Unlock()
+
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	sstore, err = Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	if len(listener.recoveries) != 2 || listener.recoveries[1].LastEntryID != 10000 {
+		t.Fatalf("recoveries %+v", listener.recoveries)
+	}
+}
+
+func TestSStore_ConsumerOffset(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	options := DefaultOptions("data").
+		WithMaxMStreamTableSize(MB).
+		WithMaxSegmentCount(2).
+		WithConsumerRetention(true)
+	sstore, err := Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.CommitOffset("c1", 1, 0); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.CommitOffset("c2", 2, 0); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.CommitOffset("c1", 1, -1); errors.Cause(err) != ErrOffset {
+		t.Fatalf("%+v", err)
+	}
+	var data = []byte(strings.Repeat("hello world,", 100))
+	var wg sync.WaitGroup
+	for i := 0; i < 5000; i++ {
+		wg.Add(1)
+		sstore.AsyncAppend(int64(i%2+1), data, -1, func(offset int64, err error) {
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	if err := sstore.CommitOffset("c1", 1, 100); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	//the consumers hold all the segments
+	segments := len(sstore.files.getSegmentFiles())
+	if segments <= 2 {
+		t.Fatalf("segments %d", segments)
+	}
+	if err := sstore.GC(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(sstore.files.getSegmentFiles()) != segments {
+		t.Fatalf("segments %d deleted", segments)
+	}
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	sstore, err = Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	if consumers := sstore.Consumers(); len(consumers) != 2 ||
+		consumers[0] != "c1" || consumers[1] != "c2" {
+		t.Fatalf("consumers %+v", consumers)
+	}
+	if offset, ok := sstore.GetOffset("c1", 1); ok == false || offset != 100 {
+		t.Fatalf("c1 offset %d %t", offset, ok)
+	}
+	if _, ok := sstore.GetOffset("c1", 2); ok {
+		t.Fatalf("c1 offset of stream 2")
+	}
+	if offsets := sstore.ConsumerOffsets("c2"); len(offsets) != 1 || offsets[2] != 0 {
+		t.Fatalf("c2 offsets %+v", offsets)
+	}
+
+	//the data read by all the consumers is deleted
+	end, _ := sstore.End(1)
+	if err := sstore.CommitOffset("c1", 1, end); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.DeleteConsumer("c2"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.GC(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(sstore.files.getSegmentFiles()) >= 2 {
+		t.Fatalf("segments %d", len(sstore.files.getSegmentFiles()))
+	}
+	if consumers := sstore.Consumers(); len(consumers) != 1 {
+		t.Fatalf("consumers %+v", consumers)
+	}
+}
+
+func TestSStore_ConsumerRetention(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	options := DefaultOptions("data").
+		WithMaxMStreamTableSize(MB).
+		WithMaxSegmentCount(2).
+		WithConsumerRetention(true)
+	sstore, err := Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	if err := sstore.CommitOffset("c1", 1, 0); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var data = []byte(strings.Repeat("hello world,", 100))
+	var fill = func(streamID int64, count int) {
+		for i := 0; i < count; i++ {
+			if _, err := sstore.Append(streamID, data, -1); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+	}
+	fill(1, 500)
+	//the segment of stream 1 is flushed alone
+	sstore.committer.barrier(sstore.committer.flush)
+	fill(2, 5000)
+	//the segments of stream 1 are kept for c1,the later ones of stream 2 are deleted
+	for i := 0; ; i++ {
+		if err := sstore.GC(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if begin, _ := sstore.Begin(2); begin > 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("stream[2] begin 0")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if begin, _ := sstore.Begin(1); begin != 0 {
+		t.Fatalf("stream[1] begin %d", begin)
+	}
+	reader, err := sstore.Reader(1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if all, err := ioutil.ReadAll(reader); err != nil || len(all) != 500*len(data) {
+		t.Fatalf("read stream[1] %d %+v", len(all), err)
+	}
+}
+
+func TestSStore_Queue(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	var now = time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	var tick int64
+	var clock = func() time.Time {
+		return now.Add(time.Duration(atomic.LoadInt64(&tick)) * time.Second)
+	}
+	options := DefaultOptions("data").WithClock(clock)
+	sstore, err := Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	queue, err := sstore.OpenQueue(1, 2, time.Second*10)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if leases, err := queue.Lease(10); err != nil || len(leases) != 0 {
+		t.Fatalf("%+v %+v", leases, err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := queue.Push([]byte(fmt.Sprintf("job %d", i))); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	var lease = func(count int, jobs ...int) []Lease {
+		leases, err := queue.Lease(count)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if len(leases) != len(jobs) {
+			t.Fatalf("leases %d expect %d", len(leases), len(jobs))
+		}
+		for i, lease := range leases {
+			if string(lease.Data) != fmt.Sprintf("job %d", jobs[i]) {
+				t.Fatalf("lease %s expect job %d", lease.Data, jobs[i])
+			}
+			if lease.Deadline.Equal(clock().Add(time.Second*10)) == false {
+				t.Fatalf("lease deadline %s", lease.Deadline)
+			}
+		}
+		return leases
+	}
+	leases := lease(4, 0, 1, 2, 3)
+	if err := queue.Ack(leases[0].Begin, leases[2].Begin); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := queue.Ack(leases[0].Begin); errors.Cause(err) != ErrLease {
+		t.Fatalf("%+v", err)
+	}
+	atomic.StoreInt64(&tick, 5)
+	lease(2, 4, 5)
+
+	//the leases of job 1,3 are expired
+	atomic.StoreInt64(&tick, 10)
+	lease(3, 1, 3, 6)
+	if queue.Pending() != 5 {
+		t.Fatalf("pending %d", queue.Pending())
+	}
+	if consumers := sstore.Consumers(); len(consumers) != 0 {
+		t.Fatalf("consumers %+v", consumers)
+	}
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	sstore, err = Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	queue, err = sstore.OpenQueue(1, 2, time.Second*10)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if queue.Pending() != 5 {
+		t.Fatalf("pending %d", queue.Pending())
+	}
+	lease(10, 7, 8, 9)
+	//the leases of job 4,5 are expired
+	atomic.StoreInt64(&tick, 15)
+	leases = lease(10, 4, 5)
+	for _, it := range leases {
+		if err := queue.Ack(it.Begin); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	atomic.StoreInt64(&tick, 100)
+	lease(10, 1, 3, 6, 7, 8, 9)
+}
+
+func TestSStore_QueueGC(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	var now = time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	var tick int64
+	var clock = func() time.Time {
+		return now.Add(time.Duration(atomic.LoadInt64(&tick)) * time.Second)
+	}
+	options := DefaultOptions("data").
+		WithClock(clock).
+		WithMaxMStreamTableSize(MB).
+		WithMaxSegmentCount(2)
+	sstore, err := Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	queue, err := sstore.OpenQueue(1, 2, time.Second*10)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var push = func(from, to int) {
+		for i := from; i < to; i++ {
+			if _, err := queue.Push([]byte(fmt.Sprintf("job %d", i))); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+	}
+	var fill = func() {
+		var data = []byte(strings.Repeat("hello world,", 100))
+		for i := 0; i < 3000; i++ {
+			if _, err := sstore.Append(3, data, -1); err != nil {
+				t.Fatalf("%+v", err)
+			}
+		}
+	}
+	push(0, 10)
+	if leases, err := queue.Lease(3); err != nil || len(leases) != 3 {
+		t.Fatalf("%+v %+v", leases, err)
+	}
+	fill()
+	push(10, 210)
+	//the state is checkpointed after the leases and acks
+	for i := 3; i < 210; i++ {
+		leases, err := queue.Lease(1)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if len(leases) != 1 || string(leases[0].Data) != fmt.Sprintf("job %d", i) {
+			t.Fatalf("leases %+v expect job %d", leases, i)
+		}
+		if err := queue.Ack(leases[0].Begin); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	fill()
+	//the segments are flushed in the background,GC until they are deleted
+	for i := 0; ; i++ {
+		if err := sstore.GC(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if begin, _ := sstore.Begin(1); begin > 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("stream[1] begin 0")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	//the records of the leases and the state before the checkpoint are deleted
+	checkpoint, ok := sstore.GetOffset(queueConsumer, 2)
+	if begin, _ := sstore.Begin(2); ok == false || begin == 0 || begin > checkpoint {
+		t.Fatalf("stream[2] begin %d checkpoint %d", begin, checkpoint)
+	}
+	//the reserved consumer of queue is not listed
+	if consumers := sstore.Consumers(); len(consumers) != 0 {
+		t.Fatalf("consumers %+v", consumers)
+	}
+	if offsets := sstore.ConsumerOffsets(queueConsumer); len(offsets) != 0 {
+		t.Fatalf("consumer offsets %+v", offsets)
+	}
+	if queue.Pending() != 3 {
+		t.Fatalf("pending %d", queue.Pending())
+	}
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	sstore, err = Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	queue, err = sstore.OpenQueue(1, 2, time.Second*10)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if queue.Pending() != 3 {
+		t.Fatalf("pending %d", queue.Pending())
+	}
+	push(210, 211)
+	//the expired leases of the records deleted are dropped
+	atomic.StoreInt64(&tick, 10)
+	leases, err := queue.Lease(10)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(leases) != 1 || string(leases[0].Data) != "job 210" {
+		t.Fatalf("leases %+v", leases)
+	}
+	if queue.Pending() != 1 {
+		t.Fatalf("pending %d", queue.Pending())
+	}
+}
+
+func TestSStore_CompactStream(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	options := DefaultOptions("data").WithMaxMStreamTableSize(64 * KB)
+	sstore, err := Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var expect = map[string]string{}
+	var put = func(i int) {
+		key := fmt.Sprintf("key-%d", i%10)
+		value := fmt.Sprintf("value-%d", i)
+		if _, err := sstore.AppendKeyed(1, []byte(key), []byte(value)); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		expect[key] = value
+	}
+	for i := 0; i < 5000; i++ {
+		put(i)
+	}
+	if _, err := sstore.DeleteKey(1, []byte("key-3")); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	delete(expect, "key-3")
+	for i := 5000; i < 15000; i++ {
+		if i%10 != 3 {
+			put(i)
+		}
+	}
+	//read the keyed records of stream and check the last values of keys
+	var check = func() (records int, tombstones int) {
+		reader, err := sstore.KeyedReader(1)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		var values = map[string]string{}
+		for {
+			record, err := reader.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			records++
+			if record.Tombstone {
+				tombstones++
+				delete(values, string(record.Key))
+			} else {
+				values[string(record.Key)] = string(record.Value)
+			}
+		}
+		if len(values) != len(expect) {
+			t.Fatalf("values %d expect %d", len(values), len(expect))
+		}
+		for key, value := range expect {
+			if values[key] != value {
+				t.Fatalf("key %s value %s expect %s", key, values[key], value)
+			}
+		}
+		return records, tombstones
+	}
+	records, _ := check()
+	if records != 15001-1000 {
+		t.Fatalf("records %d", records)
+	}
+	//the key-compacted stream accepts the keyed records only
+	if _, err := sstore.AppendRecord(1, []byte("hello"), -1); errors.Cause(err) != ErrStreamMode {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := sstore.Append(1, []byte("hello"), -1); errors.Cause(err) != ErrStreamMode {
+		t.Fatalf("%+v", err)
+	}
+	//the segments flushed after the data of stream 1
+	for i := 0; i < 1000; i++ {
+		if _, err := sstore.AppendRecord(2, []byte(strings.Repeat("hello", 20)), -1); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if _, err := sstore.AppendKeyed(2, []byte("key"), []byte("value")); errors.Cause(err) != ErrStreamMode {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.CompactStream(2); errors.Cause(err) != ErrStreamMode {
+		t.Fatalf("%+v", err)
+	}
+	end, _ := sstore.End(1)
+	//the offsets and sequences of the last records of keys
+	var lasts = map[string][2]int64{}
+	keyed, err := sstore.KeyedReader(1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for record, err := keyed.Next(); err != io.EOF; record, err = keyed.Next() {
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		seq, _, err := sstore.RecordAt(1, record.Begin)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		lasts[string(record.Key)] = [2]int64{record.Begin, seq}
+	}
+	reader, err := sstore.Reader(1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	original, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	reader, err = sstore.Reader(1)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := io.ReadFull(reader, make([]byte, 100)); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if err := sstore.CompactStream(1); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	compacted, tombstones := check()
+	if compacted >= records/2 || tombstones != 1 {
+		t.Fatalf("records %d tombstones %d after compaction", compacted, tombstones)
+	}
+	if e, _ := sstore.End(1); e != end {
+		t.Fatalf("end %d expect %d", e, end)
+	}
+	begin, _ := sstore.Begin(1)
+	if begin != 0 {
+		t.Fatalf("begin %d", begin)
+	}
+	//the reader in the data compacted reads the segment replaced
+	var data = make([]byte, 1000)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if bytes.Equal(data, original[100:1100]) == false {
+		t.Fatalf("the data of reader changed by compaction")
+	}
+	//the records kept have the offsets and sequences of them
+	var checkLasts = func() {
+		for key, last := range lasts {
+			if key == "key-3" {
+				continue
+			}
+			if seq, offset, err := sstore.RecordAt(1, last[0]); err != nil || offset != last[0] || seq != last[1] {
+				t.Fatalf("RecordAt %d %d %+v expect %+v", seq, offset, err, last)
+			}
+			if offset, err := sstore.OffsetOfRecord(1, last[1]); err != nil || offset != last[0] {
+				t.Fatalf("OffsetOfRecord %d %+v expect %+v", offset, err, last)
+			}
+			reader, err := sstore.KeyedReader(1)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if err := reader.SeekRecord(last[0]); err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if record, err := reader.Next(); err != nil || string(record.Key) != key || record.Begin != last[0] {
+				t.Fatalf("record %+v %+v", record, err)
+			}
+		}
+	}
+	checkLasts()
+	//the records deleted are skipped
+	if seq, offset, err := sstore.RecordAt(1, 0); err != nil || offset == 0 || seq == 0 {
+		t.Fatalf("RecordAt %d %d %+v", seq, offset, err)
+	}
+	if offset, err := sstore.OffsetOfRecord(1, 0); err != nil || offset == 0 {
+		t.Fatalf("OffsetOfRecord %d %+v", offset, err)
+	}
+	//the compacted segment appended last has the LastEntryID of an older one
+	var lastEntryID int64
+	for _, filename := range sstore.files.getSegmentFiles() {
+		if segment := sstore.committer.getSegment(filename); segment.meta.Compacted == false {
+			lastEntryID = segment.lastEntryID()
+		}
+	}
+	if sstore.lastSegmentEntryID() != lastEntryID {
+		t.Fatalf("lastSegmentEntryID %d expect %d", sstore.lastSegmentEntryID(), lastEntryID)
+	}
+	var buffer bytes.Buffer
+	if err := sstore.WriteSnapshot(&buffer); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	sstore, err = Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	if records, _ := check(); records != compacted {
+		t.Fatalf("records %d expect %d after reload", records, compacted)
+	}
+	checkLasts()
+
+	//the tombstone is dropped by the second compaction
+	segments := len(sstore.files.getSegmentFiles())
+	if err := sstore.CompactStream(1); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, tombstones := check(); tombstones != 0 {
+		t.Fatalf("tombstones %d", tombstones)
+	}
+	if len(sstore.files.getSegmentFiles()) != segments {
+		t.Fatalf("the compacted segment replaced is not deleted")
+	}
+	if err := sstore.GC(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func TestSStore_StreamCatalog(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	options := DefaultOptions("data")
+	sstore, err := Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := sstore.Append(5, []byte("hello world"), -1); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	//the IDs are allocated in the range of catalog
+	orders, err := sstore.CreateStream("orders", map[string]string{"owner": "shop"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if orders != catalogStreamID {
+		t.Fatalf("orders %d", orders)
+	}
+	users, err := sstore.CreateStream("users", nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if users != catalogStreamID+1 {
+		t.Fatalf("users %d", users)
+	}
+	//the stream in the range of catalog is appended after it is allocated
+	if _, err := sstore.Append(users, []byte("hello world"), -1); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := sstore.Append(users+1, []byte("hello world"), -1); errors.Cause(err) != ErrInvalidStreamID {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := sstore.AppendBatch([]AppendOp{{StreamID: 5, Data: []byte("hello")},
+		{StreamID: users + 1, Data: []byte("world")}}); errors.Cause(err) != ErrInvalidStreamID {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := sstore.CreateStream("orders", nil); errors.Cause(err) != ErrStreamExist {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.SetStreamAttrs("users", map[string]string{"retention": "7d"}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.SetStreamAttrs("payments", nil); errors.Cause(err) != ErrNoFindStream {
+		t.Fatalf("%+v", err)
+	}
+	tmp, err := sstore.CreateStream("tmp", nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.DropStream("tmp"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	var check = func(sstore *SStore) {
+		t.Helper()
+		meta, ok := sstore.LookupStream("orders")
+		if ok == false || meta.StreamID != orders || meta.Attrs["owner"] != "shop" {
+			t.Fatalf("orders %+v", meta)
+		}
+		meta, ok = sstore.StreamMeta(users)
+		if ok == false || meta.Name != "users" || meta.Attrs["retention"] != "7d" {
+			t.Fatalf("users %+v", meta)
+		}
+		if _, ok := sstore.LookupStream("tmp"); ok {
+			t.Fatalf("tmp not dropped")
+		}
+		if catalog := sstore.Catalog(); len(catalog) != 2 ||
+			catalog[0].Name != "orders" || catalog[1].Name != "users" {
+			t.Fatalf("catalog %+v", catalog)
+		}
+	}
+	check(sstore)
+
+	//the catalog is kept in the snapshot of manifest
+	sstore.files.maxJournalSize = 0
+	sstore.files.makeSnapshot()
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	sstore, err = Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	check(sstore)
+	//the ID of the stream dropped is not allocated again
+	if streamID, err := sstore.CreateStream("tmp", nil); err != nil || streamID != tmp+1 {
+		t.Fatalf("%d %+v", streamID, err)
+	}
+	if err := sstore.DropStream("tmp"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	//the catalog is restored with the streams
+	var buffer bytes.Buffer
+	if err := sstore.WriteSnapshot(&buffer); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := sstore.CreateStream("events", nil); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.RestoreSnapshot(&buffer); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	check(sstore)
+}
+
+func TestSStore_Streams(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	sstore, err := Open(DefaultOptions("data").WithMaxMStreamTableSize(MB))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	var data = []byte(strings.Repeat("hello world,", 100))
+	var wg sync.WaitGroup
+	for i := 0; i < 5000; i++ {
+		wg.Add(1)
+		sstore.AsyncAppend(int64(i%5+1), data, -1, func(offset int64, err error) {
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			wg.Done()
+		})
+	}
+	wg.Wait()
+
+	//the streams are written while they are iterated
+	var stop = make(chan struct{})
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := sstore.Append(3, data, -1); err != nil {
+				t.Errorf("%+v", err)
+				return
+			}
+		}
+	}()
+	var streams []StreamInfo
+	var pages int
+	for query, ok := (StreamQuery{From: 2, HasFrom: true, To: 5, Limit: 2}), true; ok; pages++ {
+		iterator := sstore.Streams(query)
+		for info, ok := iterator.Next(); ok; info, ok = iterator.Next() {
+			streams = append(streams, info)
+		}
+		query, ok = iterator.NextPage()
+	}
+	close(stop)
+	<-done
+	if pages != 2 || len(streams) != 3 {
+		t.Fatalf("pages %d streams %+v", pages, streams)
+	}
+	for i, info := range streams {
+		if info.StreamID != int64(i+2) {
+			t.Fatalf("stream %+v", info)
+		}
+		if info.Begin != 0 || info.End < 1000*int64(len(data)) ||
+			info.MemSize+info.DiskSize < info.End-info.Begin {
+			t.Fatalf("stream %+v", info)
+		}
+		if info.Segments == 0 || info.DiskSize == 0 {
+			t.Fatalf("stream %+v not flushed", info)
+		}
+	}
+
+	//the zero query has the streams of negative IDs
+	if _, err := sstore.Append(-1, data, -1); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var streamIDs []int64
+	for query, ok := (StreamQuery{Limit: 4}), true; ok; {
+		iterator := sstore.Streams(query)
+		for info, ok := iterator.Next(); ok; info, ok = iterator.Next() {
+			streamIDs = append(streamIDs, info.StreamID)
+		}
+		query, ok = iterator.NextPage()
+	}
+	if len(streamIDs) != 6 || streamIDs[0] != -1 || streamIDs[5] != 5 {
+		t.Fatalf("streams %+v", streamIDs)
+	}
+}
+
+func TestSStore_ReservedStreamID(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	sstore, err := Open(DefaultOptions("data"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	for _, streamID := range []int64{batchStreamID, producerStreamID,
+		epochStreamID, fencedStreamID, consumerStreamID} {
+		if _, err := sstore.Append(streamID, []byte("hello"), -1); errors.Cause(err) != ErrInvalidStreamID {
+			t.Fatalf("%+v", err)
+		}
+		if _, err := sstore.AppendBatch([]AppendOp{{StreamID: 1, Data: []byte("hello"), Offset: -1},
+			{StreamID: streamID, Data: []byte("hello"), Offset: -1}}); errors.Cause(err) != ErrInvalidStreamID {
+			t.Fatalf("%+v", err)
+		}
+		if _, err := sstore.AppendIdempotent(1, 1, streamID, []byte("hello"), -1); errors.Cause(err) != ErrInvalidStreamID {
+			t.Fatalf("%+v", err)
+		}
+		if _, err := sstore.AppendWithEpoch(1, streamID, []byte("hello"), -1); errors.Cause(err) != ErrInvalidStreamID {
+			t.Fatalf("%+v", err)
+		}
+		if err := sstore.SetEpoch(streamID, 1); errors.Cause(err) != ErrInvalidStreamID {
+			t.Fatalf("%+v", err)
+		}
+		if err := sstore.CommitOffset("c1", streamID, 0); errors.Cause(err) != ErrInvalidStreamID {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if sstore.Exist(1) {
+		t.Fatalf("batch with reserved stream appended")
+	}
+	if _, err := sstore.Append(math.MinInt64+reservedStreamIDs, []byte("hello"), -1); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func TestSStore_RecordMode(t *testing.T) {
+	os.RemoveAll("data")
+	defer os.RemoveAll("data")
+	options := DefaultOptions("data").WithMaxMStreamTableSize(1000)
+	sstore, err := Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var data = []byte("hello world")
+	//the raw data looks like a record frame
+	if _, err := sstore.Append(1, encodeRecord(data), -1); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := sstore.AppendRecord(1, data, -1); errors.Cause(err) != ErrStreamMode {
+		t.Fatalf("append record to raw stream %+v", err)
+	}
+	var check = func(sstore *SStore) {
+		t.Helper()
+		if _, err := sstore.Append(2, data, -1); errors.Cause(err) != ErrStreamMode {
+			t.Fatalf("append raw data to record stream %+v", err)
+		}
+		if _, err := sstore.AppendBatch([]AppendOp{
+			{StreamID: 1, Data: data, Offset: -1},
+			{StreamID: 2, Data: data, Offset: -1},
+		}); errors.Cause(err) != ErrStreamMode {
+			t.Fatalf("append raw batch to record stream %+v", err)
+		}
+		if _, err := sstore.AppendRecord(2, data, -1); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if _, err := sstore.Append(1, data, -1); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := sstore.AppendRecord(2, data, -1); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	check(sstore)
+	if err := sstore.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	//the mode is kept by the segments and journals
+	sstore, err = Open(options)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	check(sstore)
+	var buffer bytes.Buffer
+	if err := sstore.WriteSnapshot(&buffer); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sstore.RestoreSnapshot(&buffer); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sstore.Close()
+	check(sstore)
+	if count, _ := sstore.recordMap.get(1); count != 0 {
+		t.Fatalf("raw stream records %d", count)
+	}
+	if count, _ := sstore.recordMap.get(2); count != 103 {
+		t.Fatalf("record stream records %d", count)
+	}
+}
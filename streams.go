@@ -0,0 +1,113 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"sort"
+)
+
+//StreamInfo is the range and sizes of stream
+type StreamInfo struct {
+	StreamID int64
+	Begin    int64
+	End      int64
+	//MemSize is the size of data in memory,DiskSize is the size of data in segments,
+	//the data being flushed is counted in both
+	MemSize  int64
+	DiskSize int64
+	//Segments is the count of segments with data of stream
+	Segments int
+}
+
+//StreamQuery filter the streams of Streams
+type StreamQuery struct {
+	//From,To is the range [From,To) of StreamID,To 0 means no upper limit.
+	//From is the lower limit only if HasFrom is true,the zero query has
+	//the streams of negative IDs too
+	From    int64
+	HasFrom bool
+	To      int64
+	//Limit is the max count of streams of the page,0 means no limit
+	Limit int
+}
+
+//StreamIterator iterate the streams in the order of StreamID,
+//the info of stream is read when it is iterated
+type StreamIterator struct {
+	sstore    *SStore
+	query     StreamQuery
+	streamIDs []int64
+	next      int
+	//more is true if there are streams after the page
+	more bool
+}
+
+//Streams return the iterator of the streams of query,
+//it is safe to call while the streams are written
+func (sstore *SStore) Streams(query StreamQuery) *StreamIterator {
+	ends, _ := sstore.endMap.CloneMap()
+	var streamIDs = make([]int64, 0, len(ends))
+	for streamID := range ends {
+		if (query.HasFrom && streamID < query.From) || (query.To != 0 && streamID >= query.To) {
+			continue
+		}
+		streamIDs = append(streamIDs, streamID)
+	}
+	sort.Slice(streamIDs, func(i, j int) bool {
+		return streamIDs[i] < streamIDs[j]
+	})
+	var more bool
+	if query.Limit > 0 && len(streamIDs) > query.Limit {
+		streamIDs = streamIDs[:query.Limit]
+		more = true
+	}
+	return &StreamIterator{
+		sstore:    sstore,
+		query:     query,
+		streamIDs: streamIDs,
+		more:      more,
+	}
+}
+
+//Next return the info of the next stream,
+//it returns false at the end of the page
+func (iterator *StreamIterator) Next() (StreamInfo, bool) {
+	for iterator.next < len(iterator.streamIDs) {
+		streamID := iterator.streamIDs[iterator.next]
+		iterator.next++
+		//the store may be restored since the iterator is created
+		end, ok := iterator.sstore.endMap.get(streamID)
+		if ok == false {
+			continue
+		}
+		var info = StreamInfo{StreamID: streamID, Begin: end, End: end}
+		if offsetIndex := iterator.sstore.indexTable.get(streamID); offsetIndex != nil {
+			offsetIndex.stat(&info)
+		}
+		return info, true
+	}
+	return StreamInfo{}, false
+}
+
+//NextPage return the query of the page after the iterator,
+//it returns false if the iterator is the last page
+func (iterator *StreamIterator) NextPage() (StreamQuery, bool) {
+	if iterator.more == false {
+		return StreamQuery{}, false
+	}
+	query := iterator.query
+	query.From = iterator.streamIDs[len(iterator.streamIDs)-1] + 1
+	query.HasFrom = true
+	return query, true
+}
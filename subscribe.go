@@ -0,0 +1,260 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+//recentEntriesCap is the count of entries committed recently the committer
+//remembers,the subscriptions read the data of them from the memory tables
+const recentEntriesCap = 64 * 1024
+
+//subscriptionBatch is the max count of entries a subscription reads ahead
+const subscriptionBatch = 256
+
+type recentEntry struct {
+	ID       int64
+	StreamID int64
+	Offset   int64
+	End      int64
+	ver      Version
+}
+
+//entryRing is the ring buffer of recent entries
+type entryRing struct {
+	l       sync.RWMutex
+	entries []recentEntry
+	head    int
+	size    int
+}
+
+func newEntryRing(cap int) *entryRing {
+	return &entryRing{
+		entries: make([]recentEntry, cap),
+	}
+}
+
+//append the entry applied,end is -1 if it failed
+func (ring *entryRing) append(e *entry, end int64) {
+	ring.l.Lock()
+	defer ring.l.Unlock()
+	item := recentEntry{
+		ID:       e.ID,
+		StreamID: e.StreamID,
+		Offset:   end - int64(len(e.data)),
+		End:      end,
+		ver:      e.ver,
+	}
+	if end == -1 {
+		item.Offset = -1
+	}
+	if ring.size == len(ring.entries) {
+		ring.entries[ring.head] = item
+		ring.head = (ring.head + 1) % len(ring.entries)
+		return
+	}
+	ring.entries[(ring.head+ring.size)%len(ring.entries)] = item
+	ring.size++
+}
+
+//read copy at most count entries from ID to buf,
+//it returns false if the entry of ID is not in the ring
+func (ring *entryRing) read(ID int64, buf []recentEntry, count int) ([]recentEntry, bool) {
+	ring.l.RLock()
+	defer ring.l.RUnlock()
+	if ring.size == 0 || ring.entries[ring.head].ID > ID {
+		return buf, false
+	}
+	var get = func(i int) *recentEntry {
+		return &ring.entries[(ring.head+i)%len(ring.entries)]
+	}
+	i := sort.Search(ring.size, func(i int) bool {
+		return get(i).ID >= ID
+	})
+	for ; i < ring.size; i++ {
+		//the entries of batch have the same ID,they are read together
+		if len(buf) >= count && buf[len(buf)-1].ID != get(i).ID {
+			break
+		}
+		buf = append(buf, *get(i))
+	}
+	return buf, true
+}
+
+//ChangeEvent is an entry committed to the store
+type ChangeEvent struct {
+	EntryID  int64
+	StreamID int64
+	//Offset is the offset of Data in the stream
+	Offset  int64
+	Version Version
+	Data    []byte
+}
+
+//Subscription iterate the entries committed in the order of entry ID
+type Subscription struct {
+	sstore  *SStore
+	shipper *journalShipper
+	events  []*ChangeEvent
+	recents []recentEntry
+	c       chan interface{}
+	isClose int32
+}
+
+//Subscribe iterate the entries of all the streams from the entry fromEntryID,
+//the entries are read from the journals while they are retained,
+//and from the memory tables for the entries committed recently.
+//the entries are read as Next is called,
+//Next returns ErrEntryGC if the entries have been deleted by GC
+func (sstore *SStore) Subscribe(fromEntryID int64) (*Subscription, error) {
+	if fromEntryID < 1 {
+		return nil, errors.Errorf("entry ID %d error", fromEntryID)
+	}
+	return &Subscription{
+		sstore: sstore,
+		shipper: &journalShipper{
+			sstore: sstore,
+			next:   fromEntryID,
+		},
+		c: make(chan interface{}),
+	}, nil
+}
+
+//Next return the next entry committed,it blocks until the entry committed
+func (sub *Subscription) Next() (*ChangeEvent, error) {
+	for len(sub.events) == 0 {
+		select {
+		case <-sub.c:
+			return nil, ErrClose
+		default:
+		}
+		wait := sub.sstore.commitNotify.wait()
+		if err := sub.fill(); err != nil {
+			return nil, err
+		}
+		if len(sub.events) > 0 {
+			break
+		}
+		select {
+		case <-sub.c:
+			return nil, ErrClose
+		case <-wait:
+		}
+	}
+	event := sub.events[0]
+	sub.events[0] = nil
+	sub.events = sub.events[1:]
+	return event, nil
+}
+
+//fill read the entries committed to the events
+func (sub *Subscription) fill() error {
+	sstore := sub.sstore
+	sstore.restoreLocker.RLock()
+	defer sstore.restoreLocker.RUnlock()
+	committer := sstore.committer
+	committed := atomic.LoadInt64(&committer.lastEntryID)
+	if sub.shipper.next > committed {
+		return nil
+	}
+	var ok bool
+	sub.recents, ok = committer.recentEntries.read(sub.shipper.next, sub.recents[:0], subscriptionBatch)
+	if ok {
+		for _, item := range sub.recents {
+			if item.End != -1 {
+				data, err := sub.readData(item)
+				if err != nil {
+					return err
+				}
+				sub.events = append(sub.events, &ChangeEvent{
+					EntryID:  item.ID,
+					StreamID: item.StreamID,
+					Offset:   item.Offset,
+					Version:  item.ver,
+					Data:     data,
+				})
+			}
+			sub.shipper.next = item.ID + 1
+		}
+		//the journal position is found again if the ring is overwritten
+		sub.shipper.filename = ""
+		sub.shipper.offset = 0
+		return nil
+	}
+	_, err := sub.shipper.ship(func(e *entry) error {
+		if e.ID > committed {
+			return errEntryPending
+		}
+		if isOffsetMarked(e.Offset) || e.StreamID == epochStreamID ||
+			e.StreamID == consumerStreamID {
+			return nil
+		}
+		var batch = []*entry{e}
+		if e.isBatch() {
+			var err error
+			if batch, err = e.batchEntries(); err != nil {
+				return err
+			}
+		}
+		for _, it := range batch {
+			sub.events = append(sub.events, &ChangeEvent{
+				EntryID:  it.ID,
+				StreamID: it.StreamID,
+				Offset:   it.Offset,
+				Version:  it.ver,
+				Data:     it.data,
+			})
+		}
+		if len(sub.events) >= subscriptionBatch {
+			return errStopShip
+		}
+		return nil
+	})
+	if err == errEntryGap {
+		return errors.WithMessage(ErrEntryGC, fmt.Sprintf("entry[%d]", sub.shipper.next))
+	}
+	return err
+}
+
+//readData read the data of entry from the stream
+func (sub *Subscription) readData(item recentEntry) ([]byte, error) {
+	reader, err := sub.sstore.indexTable.reader(item.StreamID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := reader.Seek(item.Offset, io.SeekStart); err != nil {
+		//the segment of data has been deleted
+		return nil, errors.WithMessage(ErrEntryGC, fmt.Sprintf("entry[%d]", item.ID))
+	}
+	data := make([]byte, item.End-item.Offset)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return data, nil
+}
+
+//Close the subscription,Next returns ErrClose after it
+func (sub *Subscription) Close() error {
+	if atomic.CompareAndSwapInt32(&sub.isClose, 0, 1) == false {
+		return errors.New("repeated close")
+	}
+	close(sub.c)
+	return nil
+}
@@ -0,0 +1,237 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//journalTailer replay the journals of a read only store,
+//it remembers the position of the last complete entry,
+//so the journal data written by the owner later can be picked up.
+type journalTailer struct {
+	l        sync.Mutex
+	sstore   *SStore
+	filename string
+	offset   int64
+	c        chan interface{}
+	s        chan interface{}
+}
+
+func newJournalTailer(sstore *SStore) *journalTailer {
+	return &journalTailer{
+		sstore: sstore,
+		c:      make(chan interface{}, 1),
+		s:      make(chan interface{}, 1),
+	}
+}
+
+//tail replay the entries appended to the journals since last call
+func (tailer *journalTailer) tail(walFiles []string) error {
+	var index int
+	if tailer.filename != "" {
+		last, err := parseFilenameIndex(tailer.filename)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for ; index < len(walFiles); index++ {
+			current, err := parseFilenameIndex(walFiles[index])
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if current >= last {
+				break
+			}
+		}
+	}
+	for ; index < len(walFiles); index++ {
+		filename := walFiles[index]
+		var offset int64
+		if filename == tailer.filename {
+			offset = tailer.offset
+		}
+		journal, err := openJournalReadOnly(filepath.Join(tailer.sstore.options.WalDir, filename))
+		if err != nil {
+			return err
+		}
+		offset, err = journal.ReadFrom(offset, tailer.apply)
+		_ = journal.Close()
+		if err != nil {
+			return err
+		}
+		tailer.filename = filename
+		tailer.offset = offset
+	}
+	//make the entries visible before return
+	tailer.sstore.committer.barrier(nil)
+	return nil
+}
+
+func (tailer *journalTailer) apply(e *entry) error {
+	sstore := tailer.sstore
+	if e.ID <= sstore.entryID {
+		return nil //skip
+	} else if e.ID != sstore.entryID+1 {
+		return errors.WithMessage(ErrWal,
+			fmt.Sprintf("e.ID[%d] sStore.entryID+1[%d] %s", e.ID, sstore.entryID+1, tailer.filename))
+	}
+	e.cb = func(int64, error) {}
+	sstore.entryID++
+	sstore.committer.queue.put(e)
+	return nil
+}
+
+//refresh reload the manifest written by the owner,
+//pick up the segments flushed and tail the journals
+func (tailer *journalTailer) refresh() error {
+	tailer.l.Lock()
+	defer tailer.l.Unlock()
+	options := tailer.sstore.options
+	manifest, err := openManifest(options.ManifestDir, options.SegmentDir, options.WalDir, true)
+	if err != nil {
+		return err
+	}
+	_ = manifest.journal.Close()
+	tailer.sstore.files.setCatalog(manifest.getCatalog())
+	//segments go first,the journals of them may be deleted by the owner
+	if err := tailer.syncSegments(manifest.getSegmentFiles()); err != nil {
+		return err
+	}
+	return tailer.tail(manifest.getWalFiles())
+}
+
+//syncSegments open the segments flushed by the owner and close
+//the segments deleted by it
+func (tailer *journalTailer) syncSegments(segmentFiles []string) error {
+	committer := tailer.sstore.committer
+	for _, filename := range segmentFiles {
+		if committer.getSegment(filename) != nil {
+			continue
+		}
+		segment, err := openSegment(filepath.Join(tailer.sstore.options.SegmentDir, filename))
+		if err != nil {
+			return err
+		}
+		//the segment is installed in the committer,
+		//no entry tailed is applied to the mStreams replaced meanwhile
+		committer.barrier(func() {
+			if segment.meta.Compacted {
+				//the compacted segments replaced are deleted by the owner later
+				_, err = committer.appendCompacted(filename, segment)
+				return
+			}
+			if err = tailer.installSegment(filename, segment); err != nil {
+				_ = segment.close()
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	committer.segmentsLocker.RLock()
+	var deleteFiles []string
+	for filename := range committer.segments {
+		deleteFiles = append(deleteFiles, filename)
+	}
+	committer.segmentsLocker.RUnlock()
+	for _, filename := range diffStrings(deleteFiles, segmentFiles) {
+		if err := committer.deleteSegment(filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//installSegment replace the data of segment in the mStreams with it,
+//it must run in the committer by committer.barrier.
+//the mStreams of read only store begin at the end of the last segment,
+//so the segment covers the front of them
+func (tailer *journalTailer) installSegment(filename string, segment *segment) error {
+	sstore := tailer.sstore
+	table := sstore.committer.mutableMStreamMap
+	var remain = map[int64]*mStream{}
+	for streamID, info := range segment.meta.OffSetInfos {
+		mStream, ok := table.mStreams[streamID]
+		if ok == false {
+			continue
+		}
+		if mStream.begin != info.Begin {
+			return errors.Errorf("segment[%s] stream[%d] begin[%d] mStream begin[%d]",
+				filename, streamID, info.Begin, mStream.begin)
+		}
+		if mStream.end > info.End {
+			remain[streamID] = mStream.cut(info.End, info.Records)
+		}
+	}
+	sstore.committer.appendSegment(filename, segment)
+	sstore.committer.modes.merge(segment.meta.Modes)
+	for streamID, info := range segment.meta.OffSetInfos {
+		if mStream, ok := table.mStreams[streamID]; ok {
+			if ms := remain[streamID]; ms != nil {
+				sstore.indexTable.update(ms)
+			}
+			sstore.indexTable.remove(mStream)
+			table.locker.Lock()
+			if ms := remain[streamID]; ms != nil {
+				table.mStreams[streamID] = ms
+				table.mSize -= ms.begin - mStream.begin
+			} else {
+				delete(table.mStreams, streamID)
+				table.mSize -= mStream.end - mStream.begin
+			}
+			table.locker.Unlock()
+		}
+		//the owner flushed the data the tailer have not read
+		if end, _ := sstore.endMap.get(streamID); end < info.End {
+			sstore.endMap.set(streamID, info.End, segment.meta.Ver)
+			sstore.recordMap.set(streamID, info.Records, segment.meta.Ver)
+			item := notifyPool.Get().(*notify)
+			item.streamID = streamID
+			item.end = info.End
+			sstore.endWatchers.notify(item)
+		}
+	}
+	if segment.meta.LastEntryID > sstore.entryID {
+		sstore.entryID = segment.meta.LastEntryID
+	}
+	return nil
+}
+
+func (tailer *journalTailer) start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-tailer.c:
+				close(tailer.s)
+				return
+			case <-ticker.C:
+				if err := tailer.refresh(); err != nil {
+					log.Printf("refresh failed %+v", err)
+				}
+			}
+		}
+	}()
+}
+
+func (tailer *journalTailer) close() {
+	close(tailer.c)
+	<-tailer.s
+}
@@ -0,0 +1,91 @@
+// Copyright 2020-2026 The sstore Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sstore
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"time"
+)
+
+//timeIndexInterval is the min interval between the items of sparse time index,
+//OffsetForTime may return the data appended at most this interval before the time
+const timeIndexInterval = time.Second
+
+//timeIndexItem is the offset of the entry appended at TS
+type timeIndexItem struct {
+	TS     int64 `json:"ts"`
+	Offset int64 `json:"offset"`
+}
+
+//timeIndexChunk is the time index of a mStream or a segment of stream
+type timeIndexChunk struct {
+	items  []timeIndexItem
+	lastTS int64
+}
+
+//OffsetForTime return the offset of stream from which the data is appended
+//at or after t.it returns the end of stream if no data is appended after t
+func (sstore *SStore) OffsetForTime(streamID int64, t time.Time) (int64, error) {
+	offsetIndex := sstore.indexTable.get(streamID)
+	if offsetIndex == nil {
+		return 0, errors.Wrapf(ErrNoFindStream, "stream[%d]", streamID)
+	}
+	ts := t.UnixNano()
+	for _, chunk := range offsetIndex.timeIndex() {
+		//the data of the chunk is all appended before t
+		if chunk.lastTS < ts || len(chunk.items) == 0 {
+			continue
+		}
+		i := sort.Search(len(chunk.items), func(i int) bool {
+			return chunk.items[i].TS > ts
+		})
+		if i > 0 {
+			i--
+		}
+		return chunk.items[i].Offset, nil
+	}
+	end, _ := sstore.endMap.get(streamID)
+	return end, nil
+}
+
+//TimeReader is the Reader of stream which seeks by time
+type TimeReader struct {
+	io.ReadSeeker
+	sstore   *SStore
+	streamID int64
+}
+
+//TimeReader create TimeReader of the stream
+func (sstore *SStore) TimeReader(streamID int64) (*TimeReader, error) {
+	reader, err := sstore.Reader(streamID)
+	if err != nil {
+		return nil, err
+	}
+	return &TimeReader{
+		ReadSeeker: reader,
+		sstore:     sstore,
+		streamID:   streamID,
+	}, nil
+}
+
+//SeekTime seek to the offset of the data appended at or after t
+func (reader *TimeReader) SeekTime(t time.Time) (int64, error) {
+	offset, err := reader.sstore.OffsetForTime(reader.streamID, t)
+	if err != nil {
+		return 0, err
+	}
+	return reader.Seek(offset, io.SeekStart)
+}
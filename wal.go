@@ -64,6 +64,28 @@ func openJournal(filename string) (*journal, error) {
 	return w, nil
 }
 
+//openJournalReadOnly open the journal for reading only,
+//it will not create the file when it not exist
+func openJournalReadOnly(filename string) (*journal, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &journal{
+		filename: filename,
+		size:     0,
+		f:        f,
+		writer:   bufio.NewWriterSize(f, 0),
+		meta: JournalMeta{
+			Filename:     filepath.Base(filename),
+			Version:      version1,
+			FirstEntryID: -1,
+			LastEntryID:  -1,
+			Old:          false,
+		},
+	}, nil
+}
+
 func (j *journal) SeekStart() error {
 	if _, err := j.f.Seek(0, io.SeekStart); err != nil {
 		return errors.WithStack(err)
@@ -141,3 +163,26 @@ func (j *journal) Read(cb func(e *entry) error) error {
 		}
 	}
 }
+
+//ReadFrom read entries from the offset of the journal,
+//it stops at the last complete entry and returns the offset after it,
+//the incomplete entry at the end may be written by other process now.
+func (j *journal) ReadFrom(offset int64, cb func(e *entry) error) (int64, error) {
+	if _, err := j.f.Seek(offset, io.SeekStart); err != nil {
+		return offset, errors.WithStack(err)
+	}
+	reader := bufio.NewReader(j.f)
+	for {
+		e, err := decodeEntry(reader)
+		if err != nil {
+			if err := errors.Cause(err); err == io.EOF || err == io.ErrUnexpectedEOF {
+				return offset, nil
+			}
+			return offset, err
+		}
+		if err := cb(e); err != nil {
+			return offset, err
+		}
+		offset += int64(4 + e.size())
+	}
+}
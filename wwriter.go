@@ -19,6 +19,7 @@ import (
 	"math"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
 type wWriter struct {
@@ -27,18 +28,231 @@ type wWriter struct {
 	commit     *entryQueue
 	files      *manifest
 	maxWalSize int64
+	//ends are the ends of streams after the entries written,
+	//the committer applies the entries later
+	ends   map[int64]int64
+	endMap *int64LockMap
+	//seqs are the sequences of producers after the entries written
+	seqs      map[int64]int64
+	producers *producerTable
+	//epochs are the epochs of streams after the entries written
+	epochs     map[int64]int64
+	epochTable *epochTable
+	//modes are the append modes of streams after the entries written
+	modes     map[int64]byte
+	modeTable *modeTable
+	clock     func() time.Time
+	lastTS    int64
+	listener  EventListener
 }
 
 func newWWriter(w *journal, queue *entryQueue,
 	commitQueue *entryQueue,
-	files *manifest, maxWalSize int64) *wWriter {
+	files *manifest, endMap *int64LockMap,
+	producers *producerTable, epochs *epochTable, modes *modeTable,
+	clock func() time.Time, listener EventListener, maxWalSize int64) *wWriter {
+	if clock == nil {
+		clock = time.Now
+	}
 	return &wWriter{
 		wal:        w,
 		queue:      queue,
 		commit:     commitQueue,
 		files:      files,
 		maxWalSize: maxWalSize,
+		ends:       make(map[int64]int64, 1024),
+		endMap:     endMap,
+		seqs:       make(map[int64]int64),
+		producers:  producers,
+		epochs:     make(map[int64]int64),
+		epochTable: epochs,
+		modes:      make(map[int64]byte),
+		modeTable:  modes,
+		clock:      clock,
+		listener:   listener,
+	}
+}
+
+//isOffsetMarked return true if the entry is marked not to append
+func isOffsetMarked(offset int64) bool {
+	return offset == offsetFailed ||
+		offset == offsetDuplicate ||
+		offset == offsetOutOfSequence ||
+		offset == offsetFenced ||
+		offset == offsetWrongMode
+}
+
+func (worker *wWriter) end(streamID int64) int64 {
+	end, ok := worker.ends[streamID]
+	if ok == false {
+		//no entry of the stream in flight,the endMap is up to date
+		end, _ = worker.endMap.get(streamID)
+	}
+	return end
+}
+
+func (worker *wWriter) seq(producerID int64) int64 {
+	seq, ok := worker.seqs[producerID]
+	if ok == false {
+		//no entry of the producer written before,the committer is up to date
+		seq, _ = worker.producers.seq(producerID)
+	}
+	return seq
+}
+
+func (worker *wWriter) epoch(streamID int64) int64 {
+	epoch, ok := worker.epochs[streamID]
+	if ok == false {
+		epoch = worker.epochTable.get(streamID)
+	}
+	return epoch
+}
+
+func (worker *wWriter) mode(streamID int64) byte {
+	mode, ok := worker.modes[streamID]
+	if ok == false {
+		mode = worker.modeTable.get(streamID)
 	}
+	return mode
+}
+
+//checkMode return true if the entry of mode can append to the stream,
+//the stream without data can be in any mode
+func (worker *wWriter) checkMode(streamID int64, mode byte) bool {
+	return worker.mode(streamID) == mode ||
+		(worker.mode(streamID) == streamModeRaw && worker.end(streamID) == 0)
+}
+
+//now return the timestamp of entry,it never goes back
+//so the entries of stream are in the order of time
+func (worker *wWriter) now() int64 {
+	ts := worker.clock().UnixNano()
+	if ts < worker.lastTS {
+		ts = worker.lastTS
+	}
+	worker.lastTS = ts
+	return ts
+}
+
+//resolveOffset set the offset of entry to the end of stream it appends to,
+//so the journal records where the data of entry is
+func (worker *wWriter) resolveOffset(e *entry) {
+	if e.isBatch() {
+		worker.resolveBatchOffset(e)
+		return
+	}
+	if e.StreamID == epochStreamID {
+		//the committer rejects the older epoch,it appends nothing
+		streamID, epoch, err := e.decodeEpoch()
+		if err != nil || epoch < worker.epoch(streamID) {
+			e.Offset = offsetFenced
+		}
+		return
+	}
+	if e.StreamID == consumerStreamID {
+		//it appends nothing
+		return
+	}
+	//the writer of the stream fenced must append with its epoch
+	if worker.epoch(e.StreamID) > 0 {
+		e.Offset = offsetFenced
+		return
+	}
+	if worker.checkMode(e.StreamID, streamModeRaw) == false {
+		e.Offset = offsetWrongMode
+		return
+	}
+	end := worker.end(e.StreamID)
+	if e.Offset != -1 && e.Offset != end {
+		e.Offset = offsetFailed
+		return
+	}
+	e.Offset = end
+}
+
+//resolveBatchOffset resolve the offsets of the entries of batch,
+//the batch fails if any of them fails
+func (worker *wWriter) resolveBatchOffset(e *entry) {
+	batch, err := e.batchEntries()
+	if err != nil {
+		//the committer rejects it with the error
+		e.Offset = offsetFailed
+		return
+	}
+	if e.StreamID == producerStreamID {
+		last := worker.seq(e.producerID)
+		if e.seq <= last {
+			e.Offset = offsetDuplicate
+			return
+		} else if e.seq != last+1 {
+			e.Offset = offsetOutOfSequence
+			return
+		}
+	}
+	for _, it := range batch {
+		//the batch without epoch is fenced by any epoch
+		if (e.StreamID != fencedStreamID && worker.epoch(it.StreamID) > 0) ||
+			e.epoch < worker.epoch(it.StreamID) {
+			e.Offset = offsetFenced
+			return
+		}
+		if worker.checkMode(it.StreamID, e.mode) == false {
+			e.Offset = offsetWrongMode
+			return
+		}
+	}
+	var ends = make(map[int64]int64, len(batch))
+	for _, it := range batch {
+		end, ok := ends[it.StreamID]
+		if ok == false {
+			end = worker.end(it.StreamID)
+		}
+		if it.Offset != -1 && it.Offset != end {
+			e.Offset = offsetFailed
+			return
+		}
+		ends[it.StreamID] = end + int64(len(it.data))
+	}
+	for streamID := range ends {
+		delete(ends, streamID)
+	}
+	for _, it := range batch {
+		end, ok := ends[it.StreamID]
+		if ok == false {
+			end = worker.end(it.StreamID)
+		}
+		it.Offset = end
+		ends[it.StreamID] = end + int64(len(it.data))
+	}
+	e.encodeBatch()
+}
+
+//updateEnds update the ends of streams after the entry written
+func (worker *wWriter) updateEnds(e *entry) {
+	if isOffsetMarked(e.Offset) {
+		return
+	}
+	if e.StreamID == epochStreamID {
+		streamID, epoch, _ := e.decodeEpoch()
+		worker.epochs[streamID] = epoch
+		return
+	}
+	if e.StreamID == consumerStreamID {
+		return
+	}
+	if e.StreamID == producerStreamID {
+		worker.seqs[e.producerID] = e.seq
+	}
+	if e.isBatch() {
+		for _, it := range e.batch {
+			worker.ends[it.StreamID] = it.Offset + int64(len(it.data))
+			if e.StreamID == recordStreamID {
+				worker.modes[it.StreamID] = e.mode
+			}
+		}
+		return
+	}
+	worker.ends[e.StreamID] = e.Offset + int64(len(e.data))
 }
 
 //append the entry to the queue of writer
@@ -67,6 +281,12 @@ func (worker *wWriter) createNewWal() error {
 	if err := worker.files.setWalHeader(header); err != nil {
 		return err
 	}
+	worker.listener.OnWalRotate(WalRotateEvent{
+		Filename:    worker.walFilename(),
+		Size:        worker.wal.Size(),
+		LastEntryID: header.LastEntryID,
+		NewFilename: filepath.Base(walFile),
+	})
 	worker.wal = wal
 	return nil
 }
@@ -82,7 +302,8 @@ func (worker *wWriter) start() {
 				e := entries[i]
 				if e.ID == closeSignal {
 					_ = worker.wal.Close()
-					worker.commit.put(e)
+					//commit the entries written before close
+					worker.commit.putEntries(append(commit, e))
 					return
 				}
 				if worker.wal.Size() > worker.maxWalSize {
@@ -91,9 +312,15 @@ func (worker *wWriter) start() {
 						continue
 					}
 				}
+				//the entries from leader have the timestamps
+				if e.ts == 0 {
+					e.ts = worker.now()
+				}
+				worker.resolveOffset(e)
 				if err := worker.wal.Write(e); err != nil {
 					e.cb(-1, err)
 				} else {
+					worker.updateEnds(e)
 					commit = append(commit, e)
 				}
 			}